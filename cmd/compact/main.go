@@ -0,0 +1,32 @@
+// compact сжимает файл хранилища: схлопывает повторы и применяет tombstone-записи.
+// Запускать, когда сервер остановлен: работающий сервер держит блокировку
+// файла и сжимает его сам с периодом -compact-interval, так что при нём
+// команда завершится с ошибкой, не трогая файл.
+package main
+
+import (
+	"errors"
+
+	"github.com/thalq/url-service/config"
+	logger "github.com/thalq/url-service/internal/middleware"
+	"github.com/thalq/url-service/internal/storage"
+)
+
+func main() {
+	logger.InitLogger()
+	cfg := config.ParseConfig()
+	if cfg.FileStoragePath == "" {
+		logger.Sugar.Fatal("File storage path is empty")
+	}
+	store, err := storage.NewFileStorage(cfg.FileStoragePath)
+	if errors.Is(err, storage.ErrStorageLocked) {
+		logger.Sugar.Fatalf("File storage %s is in use by a running server, it compacts the file itself", cfg.FileStoragePath)
+	}
+	if err != nil {
+		logger.Sugar.Fatalf("Failed to load file storage: %v", err)
+	}
+	defer store.Close()
+	if err := store.Compact(); err != nil {
+		logger.Sugar.Fatalf("Failed to compact file storage: %v", err)
+	}
+}
@@ -0,0 +1,36 @@
+// migrate применяет или откатывает миграции схемы Postgres.
+// Сервер применяет миграции сам при старте, утилита нужна для отката.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/thalq/url-service/config"
+	database "github.com/thalq/url-service/internal/dataBase"
+	logger "github.com/thalq/url-service/internal/middleware"
+)
+
+func main() {
+	logger.InitLogger()
+	down := flag.Int("down", 0, "number of migrations to roll back")
+	cfg := config.ParseConfig()
+
+	db, err := sql.Open("pgx", cfg.DatabaseDNS)
+	if err != nil {
+		logger.Sugar.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if *down > 0 {
+		err = database.Rollback(ctx, db, *down)
+	} else {
+		err = database.Migrate(ctx, db)
+	}
+	if err != nil {
+		logger.Sugar.Fatalf("Migration failed: %v", err)
+	}
+}
@@ -1,17 +1,48 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/thalq/url-service/config"
 	logger "github.com/thalq/url-service/internal/middleware"
 	"github.com/thalq/url-service/internal/routers"
 )
 
+// shutdownTimeout — сколько ждать завершения текущих запросов при остановке.
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 	logger.InitLogger()
 	cfg := config.ParseConfig()
-	r := routers.NewRouter(cfg)
+	r, closeRouter := routers.NewRouter(cfg)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{Addr: cfg.Address, Handler: r}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Sugar.Errorf("Failed to shut down server: %v", err)
+		}
+	}()
+
 	logger.Sugar.Infoln("Running server on", cfg.Address)
-	logger.Sugar.Fatal(http.ListenAndServe(cfg.Address, r))
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		logger.Sugar.Fatal(err)
+	}
+	// ListenAndServe возвращается сразу после начала Shutdown, дожидаемся
+	// завершения текущих запросов, прежде чем закрывать воркеры
+	<-stopped
+	closeRouter()
+	logger.Sugar.Infoln("Server stopped")
 }
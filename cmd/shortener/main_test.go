@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"strings"
 	"testing"
 
@@ -21,10 +20,11 @@ func TestMainHandler(t *testing.T) {
 	cfg := config.Config{
 		Address:         ":8080",
 		BaseURL:         "http://localhost:8080",
-		FileStoragePath: "test_data.log",
+		FileStoragePath: "",
 		DatabaseDNS:     "",
 	}
-	r := routers.NewRouter(cfg)
+	r, closeRouter := routers.NewRouter(cfg)
+	defer closeRouter()
 
 	t.Run("POST valid URL", func(t *testing.T) {
 		req, err := http.NewRequest(http.MethodPost, "/", strings.NewReader("http://example.com"))
@@ -90,7 +90,7 @@ func TestMainHandler(t *testing.T) {
 	})
 
 	t.Run("POST /api/shorten valid URL", func(t *testing.T) {
-		requestBody, _ := json.Marshal(models.Request{URL: "http://example.com"})
+		requestBody, _ := json.Marshal(models.Request{URL: "http://example.org"})
 		req, err := http.NewRequest(http.MethodPost, "/api/shorten", bytes.NewReader(requestBody))
 		assert.NoError(t, err)
 
@@ -131,7 +131,4 @@ func TestMainHandler(t *testing.T) {
 
 		assert.Equal(t, http.StatusBadRequest, rec.Code)
 	})
-
-	err := os.Remove(cfg.FileStoragePath)
-	assert.NoError(t, err)
 }
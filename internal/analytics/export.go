@@ -0,0 +1,81 @@
+package analytics
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thalq/url-service/internal/models"
+)
+
+// Форматы выгрузки переходов.
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// csvHeader — колонки CSV-выгрузки, в порядке csvRecord.
+var csvHeader = []string{
+	"time", "short_url", "referrer", "user_agent", "ip_hash", "accept_language",
+	"browser", "os", "device", "bot", "source", "channel", "country", "region", "city", "variant",
+}
+
+func csvRecord(click models.Click) []string {
+	return []string{
+		click.Time.UTC().Format(time.RFC3339Nano), click.ShortURL, csvText(click.Referrer), csvText(click.UserAgent),
+		click.IPHash, csvText(click.AcceptLanguage), click.Browser, click.OS, click.Device,
+		strconv.FormatBool(click.Bot), csvText(click.Source), click.Channel, click.Country, click.Region, click.City, click.Variant,
+	}
+}
+
+// csvText экранирует присланное посетителем значение: таблицы считают
+// ячейку, начинающуюся с =, +, -, @, табуляции или возврата каретки,
+// формулой, поэтому перед таким значением ставится апостроф.
+func csvText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// Export пишет в w переходы, подходящие под filter, в формате format.
+// Переходы читаются из store по одному и сразу уходят в w, так что
+// выгрузка не держит их в памяти целиком. Если обход падает раньше, чем
+// заполнился буфер, в w ничего не пишется.
+func Export(ctx context.Context, store Store, filter Filter, format string, w io.Writer) error {
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return err
+		}
+		err := store.ScanClicks(ctx, filter, func(click models.Click) error {
+			return cw.Write(csvRecord(classify(click)))
+		})
+		if err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+	case FormatNDJSON:
+		bw := bufio.NewWriter(w)
+		encoder := json.NewEncoder(bw)
+		err := store.ScanClicks(ctx, filter, func(click models.Click) error {
+			click = classify(click)
+			return encoder.Encode(&click)
+		})
+		if err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+	return ErrUnknownFormat
+}
@@ -0,0 +1,70 @@
+package analytics
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thalq/url-service/internal/models"
+)
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	assert.NoError(t, store.SaveClicks(ctx, []models.Click{
+		{Time: now, ShortURL: "a", Referrer: "https://t.me/x", UserAgent: "curl/8.5.0", IPHash: "h1", Country: "GB", Variant: "b"},
+		{Time: now.Add(time.Hour), ShortURL: "b", UserAgent: `Agent, with "quotes"`},
+		{Time: now, ShortURL: "other"},
+	}))
+	filter := Filter{ShortURLs: []string{"a", "b"}}
+
+	var buf bytes.Buffer
+	assert.NoError(t, Export(ctx, store, filter, FormatCSV, &buf))
+	assert.Equal(t, strings.Join([]string{
+		"time,short_url,referrer,user_agent,ip_hash,accept_language,browser,os,device,bot,source,channel,country,region,city,variant",
+		"2024-05-01T12:00:00Z,a,https://t.me/x,curl/8.5.0,h1,,Other,Other,other,true,t.me,social,GB,,,b",
+		`2024-05-01T13:00:00Z,b,,"Agent, with ""quotes""",,,Other,Other,desktop,false,,direct,,,,`,
+		"",
+	}, "\n"), buf.String())
+
+	buf.Reset()
+	assert.NoError(t, Export(ctx, store, Filter{ShortURLs: []string{"a"}}, FormatNDJSON, &buf))
+	var click models.Click
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &click))
+	assert.Equal(t, "a", click.ShortURL)
+	assert.True(t, click.Bot)
+	assert.Equal(t, 1, strings.Count(buf.String(), "\n"))
+
+	assert.ErrorIs(t, Export(ctx, store, filter, "xml", &buf), ErrUnknownFormat)
+}
+
+func TestExportEscapesFormulas(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	assert.NoError(t, store.SaveClicks(ctx, []models.Click{
+		{Time: now, ShortURL: "a", Referrer: `=HYPERLINK("https://evil.example","x")`, UserAgent: "@SUM(1)",
+			AcceptLanguage: "-1+1", Browser: "Other", OS: "Other", Device: "other"},
+	}))
+
+	var buf bytes.Buffer
+	assert.NoError(t, Export(ctx, store, Filter{ShortURLs: []string{"a"}}, FormatCSV, &buf))
+	records, err := csv.NewReader(&buf).ReadAll()
+	assert.NoError(t, err)
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, `'=HYPERLINK("https://evil.example","x")`, records[1][2])
+		assert.Equal(t, "'@SUM(1)", records[1][3])
+		assert.Equal(t, "'-1+1", records[1][5])
+	}
+
+	// в NDJSON значения остаются как есть
+	buf.Reset()
+	assert.NoError(t, Export(ctx, store, Filter{ShortURLs: []string{"a"}}, FormatNDJSON, &buf))
+	assert.Contains(t, buf.String(), `"user_agent":"@SUM(1)"`)
+}
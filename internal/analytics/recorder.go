@@ -0,0 +1,174 @@
+package analytics
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/thalq/url-service/internal/classifier"
+	"github.com/thalq/url-service/internal/geoip"
+	logger "github.com/thalq/url-service/internal/middleware"
+	"github.com/thalq/url-service/internal/models"
+)
+
+type RecorderOptions struct {
+	// BufferSize — сколько переходов может ждать записи. Когда буфер полон,
+	// новые переходы отбрасываются, а не задерживают редирект.
+	BufferSize    int
+	BatchSize     int
+	FlushInterval time.Duration
+	// IPSalt подмешивается к IP перед хешированием, без неё хеш IPv4
+	// легко обратить перебором.
+	IPSalt string
+	// GeoIP определяет страну и город перехода по IP, nil — не определять.
+	GeoIP *geoip.DB
+}
+
+const (
+	defaultBufferSize    = 10000
+	defaultBatchSize     = 500
+	defaultFlushInterval = time.Second
+	saveTimeout          = 30 * time.Second
+)
+
+// event — переход в буфере. Сырой IP не покидает Recorder.
+type event struct {
+	click models.Click
+	ip    string
+}
+
+// Recorder копит переходы в буфере и пачками пишет их в Store. Ошибки
+// записи только логируются: аналитика не должна мешать редиректам.
+type Recorder struct {
+	store   Store
+	opts    RecorderOptions
+	events  chan event
+	dropped atomic.Int64
+	done    chan struct{}
+}
+
+// NewRecorder создаёт Recorder и запускает его воркер.
+func NewRecorder(store Store, opts RecorderOptions) *Recorder {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = defaultBufferSize
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultFlushInterval
+	}
+	r := &Recorder{
+		store:  store,
+		opts:   opts,
+		events: make(chan event, opts.BufferSize),
+		done:   make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// Record ставит переход по ссылке shortURL в буфер и сразу возвращается.
+// variant — имя выпавшего варианта A/B-теста или пустая строка.
+// Вызов на nil Recorder ничего не делает.
+func (r *Recorder) Record(req *http.Request, shortURL, variant, ip string) {
+	if r == nil {
+		return
+	}
+	e := event{
+		click: models.Click{
+			Time:           time.Now().UTC(),
+			ShortURL:       shortURL,
+			Referrer:       req.Referer(),
+			UserAgent:      req.UserAgent(),
+			AcceptLanguage: req.Header.Get("Accept-Language"),
+			Variant:        variant,
+		},
+		ip: ip,
+	}
+	select {
+	case r.events <- e:
+	default:
+		if r.dropped.Add(1)%1000 == 1 {
+			logger.Sugar.Warnf("Click buffer is full, %d clicks dropped", r.dropped.Load())
+		}
+	}
+}
+
+// Dropped возвращает число переходов, отброшенных из-за полного буфера.
+func (r *Recorder) Dropped() int64 {
+	return r.dropped.Load()
+}
+
+func (r *Recorder) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.Click, 0, r.opts.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), saveTimeout)
+		if err := r.store.SaveClicks(ctx, batch); err != nil {
+			logger.Sugar.Errorf("Failed to save %d clicks: %v", len(batch), err)
+		}
+		cancel()
+		batch = make([]models.Click, 0, r.opts.BatchSize)
+	}
+	for {
+		select {
+		case e, ok := <-r.events:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, r.enrich(e))
+			if len(batch) >= r.opts.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// enrich дополняет переход данными, которые дорого считать в обработчике.
+func (r *Recorder) enrich(e event) models.Click {
+	click := classify(e.click)
+	if e.ip != "" {
+		loc := r.opts.GeoIP.Lookup(e.ip)
+		click.Country, click.Region, click.City = loc.Country, loc.Region, loc.City
+		click.IPHash = hashIP(r.opts.IPSalt, e.ip)
+	}
+	return click
+}
+
+// classify заполняет поля разбора User-Agent и Referer. Переходы, записанные
+// до появления классификации, разбираются при чтении.
+func classify(click models.Click) models.Click {
+	if click.Browser != "" {
+		return click
+	}
+	agent := classifier.ParseUserAgent(click.UserAgent)
+	source := classifier.ParseReferrer(click.Referrer)
+	click.Browser, click.OS, click.Device, click.Bot = agent.Browser, agent.OS, agent.Device, agent.Bot
+	click.Source, click.Channel = source.Domain, source.Channel
+	return click
+}
+
+func hashIP(salt, ip string) string {
+	sum := sha256.Sum256([]byte(salt + ip))
+	return hex.EncodeToString(sum[:])
+}
+
+// Close дописывает буфер и останавливает воркер. После Close вызывать
+// Record нельзя.
+func (r *Recorder) Close() {
+	close(r.events)
+	<-r.done
+}
@@ -0,0 +1,150 @@
+package analytics
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/thalq/url-service/internal/geoip"
+	logger "github.com/thalq/url-service/internal/middleware"
+	"github.com/thalq/url-service/internal/models"
+)
+
+func TestRecorder(t *testing.T) {
+	logger.InitLogger()
+	geo, err := geoip.Open("../geoip/testdata/GeoIP2-City-Test.mmdb")
+	assert.NoError(t, err)
+	defer geo.Close()
+	store := NewMemoryStore()
+	r := NewRecorder(store, RecorderOptions{BatchSize: 2, FlushInterval: time.Hour, IPSalt: "salt", GeoIP: geo})
+
+	req := httptest.NewRequest("GET", "/abc", nil)
+	req.Header.Set("Referer", "https://example.com/page")
+	req.Header.Set("User-Agent", "test-agent")
+	req.Header.Set("Accept-Language", "ru-RU,ru;q=0.9")
+	r.Record(req, "abc", "b", "81.2.69.142")
+	r.Record(req, "abc", "", "81.2.69.142")
+	// пачка из двух переходов пишется, не дожидаясь таймера
+	assert.Eventually(t, func() bool { return len(store.Clicks()) == 2 }, time.Second, 10*time.Millisecond)
+
+	r.Record(req, "def", "", "")
+	r.Close()
+	clicks := store.Clicks()
+	assert.Len(t, clicks, 3)
+	assert.Equal(t, "abc", clicks[0].ShortURL)
+	assert.Equal(t, "https://example.com/page", clicks[0].Referrer)
+	assert.Equal(t, "test-agent", clicks[0].UserAgent)
+	assert.Equal(t, "ru-RU,ru;q=0.9", clicks[0].AcceptLanguage)
+	assert.Equal(t, "example.com", clicks[0].Source)
+	assert.Equal(t, "referral", clicks[0].Channel)
+	assert.Equal(t, "Other", clicks[0].Browser)
+	assert.Equal(t, hashIP("salt", "81.2.69.142"), clicks[0].IPHash)
+	assert.NotContains(t, clicks[0].IPHash, "81.2.69.142")
+	assert.NotEqual(t, hashIP("other", "81.2.69.142"), clicks[0].IPHash)
+	assert.Equal(t, "GB", clicks[0].Country)
+	assert.Equal(t, "England", clicks[0].Region)
+	assert.Equal(t, "London", clicks[0].City)
+	assert.Equal(t, "b", clicks[0].Variant)
+	assert.Empty(t, clicks[1].Variant)
+	assert.Empty(t, clicks[2].IPHash)
+	assert.Empty(t, clicks[2].Country)
+}
+
+// blockingStore не даёт воркеру освободить буфер.
+type blockingStore struct {
+	*MemoryStore
+	release chan struct{}
+}
+
+func (s *blockingStore) SaveClicks(ctx context.Context, clicks []models.Click) error {
+	<-s.release
+	return nil
+}
+
+func TestRecorderDropsWhenFull(t *testing.T) {
+	logger.InitLogger()
+	store := &blockingStore{MemoryStore: NewMemoryStore(), release: make(chan struct{})}
+	r := NewRecorder(store, RecorderOptions{BufferSize: 2, BatchSize: 1, FlushInterval: time.Hour})
+
+	req := httptest.NewRequest("GET", "/abc", nil)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			r.Record(req, "abc", "", "")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Record заблокировался на полном буфере")
+	}
+	assert.Positive(t, r.Dropped())
+	close(store.release)
+	r.Close()
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clicks.log")
+	store, err := NewFileStore(path)
+	assert.NoError(t, err)
+	now := time.Now().UTC().Truncate(time.Second)
+	assert.NoError(t, store.SaveClicks(context.Background(), []models.Click{
+		{Time: now, ShortURL: "a"},
+		{Time: now, ShortURL: "b", Referrer: "https://example.com"},
+	}))
+	assert.NoError(t, store.Close())
+
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+	var got []models.Click
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var click models.Click
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &click))
+		got = append(got, click)
+	}
+	assert.Equal(t, []models.Click{
+		{Time: now, ShortURL: "a"},
+		{Time: now, ShortURL: "b", Referrer: "https://example.com"},
+	}, got)
+}
+
+func TestPostgresStore(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectBegin()
+	prep := mock.ExpectPrepare(regexp.QuoteMeta("INSERT INTO clicks (short_url, clicked_at, referrer, user_agent, ip_hash, accept_language, browser, os, device, is_bot, source, channel, country, region, city, variant)"))
+	prep.ExpectExec().WithArgs("a", now, "", "ua", "hash", "en", "Chrome", "Windows", "desktop", false, "", "direct", "GB", "England", "London", "b").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	prep.ExpectExec().WithArgs("b", now, "ref", "", "", "", "", "", "", true, "", "", "", "", "", "").WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, NewPostgresStore(db).SaveClicks(context.Background(), []models.Click{
+		{Time: now, ShortURL: "a", UserAgent: "ua", IPHash: "hash", AcceptLanguage: "en",
+			Browser: "Chrome", OS: "Windows", Device: "desktop", Channel: "direct", Country: "GB", Region: "England", City: "London", Variant: "b"},
+		{Time: now, ShortURL: "b", Referrer: "ref", Bot: true},
+	}))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// anyArgs пропускает аргументы как есть: стандартный конвертер sqlmock
+// не принимает срезы.
+type anyArgs struct{}
+
+func (anyArgs) ConvertValue(v any) (driver.Value, error) {
+	return v, nil
+}
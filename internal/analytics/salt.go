@@ -0,0 +1,52 @@
+package analytics
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+)
+
+// NewSalt возвращает случайную соль для хеширования IP.
+func NewSalt() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// LoadSalt читает соль из path, а если файла нет, создаёт его с новой
+// солью. Соль должна переживать перезапуски: иначе хеши одного IP до и
+// после рестарта не совпадут.
+func LoadSalt(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		salt := strings.TrimSpace(string(data))
+		if salt == "" {
+			return "", errors.New("empty salt file " + path)
+		}
+		return salt, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	salt, err := NewSalt()
+	if err != nil {
+		return "", err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.WriteString(salt + "\n"); err != nil {
+		file.Close()
+		return "", err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return "", err
+	}
+	return salt, file.Close()
+}
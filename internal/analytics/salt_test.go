@@ -0,0 +1,32 @@
+package analytics
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadSalt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clicks.log.salt")
+
+	salt, err := LoadSalt(path)
+	assert.NoError(t, err)
+	assert.Len(t, salt, 64)
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	again, err := LoadSalt(path)
+	assert.NoError(t, err)
+	assert.Equal(t, salt, again)
+
+	other, err := NewSalt()
+	assert.NoError(t, err)
+	assert.NotEqual(t, salt, other)
+
+	assert.NoError(t, os.WriteFile(path, []byte("\n"), 0600))
+	_, err = LoadSalt(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,126 @@
+package analytics
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/thalq/url-service/internal/models"
+)
+
+// Шаг временного ряда статистики.
+const (
+	IntervalDay  = "day"
+	IntervalHour = "hour"
+)
+
+const (
+	// topLimit — сколько значений отдавать в топах.
+	topLimit = 10
+	// maxSeriesPoints ограничивает длину ряда: год по часам, 27 лет по дням.
+	maxSeriesPoints = 24 * 366
+)
+
+var ErrInvalidRange = errors.New("invalid stats range")
+
+func intervalStep(interval string) (time.Duration, bool) {
+	switch interval {
+	case IntervalDay:
+		return 24 * time.Hour, true
+	case IntervalHour:
+		return time.Hour, true
+	}
+	return 0, false
+}
+
+// Stats считает статистику переходов по ссылке shortURL за [from, to).
+// Ряд разбит на дни или часы по UTC и включает интервалы без переходов.
+func Stats(ctx context.Context, store Store, shortURL string, from, to time.Time, interval string) (models.LinkStats, error) {
+	step, ok := intervalStep(interval)
+	if !ok || !from.Before(to) {
+		return models.LinkStats{}, ErrInvalidRange
+	}
+	from, to = from.UTC(), to.UTC()
+	start := from.Truncate(step)
+	if int(to.Sub(start)/step) >= maxSeriesPoints {
+		return models.LinkStats{}, ErrInvalidRange
+	}
+
+	stats := models.LinkStats{ShortURL: shortURL, From: from, To: to, Interval: interval}
+	for t := start; t.Before(to); t = t.Add(step) {
+		stats.Series = append(stats.Series, models.StatsPoint{Time: t})
+	}
+	visitors := make(map[string]struct{})
+	referrers := make(map[string]int)
+	channels := make(map[string]int)
+	browsers := make(map[string]int)
+	systems := make(map[string]int)
+	devices := make(map[string]int)
+	countries := make(map[string]int)
+	cities := make(map[string]int)
+	variants := make(map[string]int)
+	filter := Filter{ShortURLs: []string{shortURL}, From: from, To: to}
+	err := store.ScanClicks(ctx, filter, func(click models.Click) error {
+		click = classify(click)
+		stats.TotalClicks++
+		stats.Series[int(click.Time.UTC().Sub(start)/step)].Clicks++
+		if click.Bot {
+			// боты учитываются в общем числе и ряде, но не в посетителях и топах
+			stats.BotClicks++
+			return nil
+		}
+		if click.IPHash != "" {
+			visitors[click.IPHash] = struct{}{}
+		}
+		if click.Source != "" {
+			referrers[click.Source]++
+		}
+		channels[click.Channel]++
+		browsers[click.Browser]++
+		systems[click.OS]++
+		devices[click.Device]++
+		if click.Country != "" {
+			countries[click.Country]++
+		}
+		if click.City != "" {
+			cities[click.City]++
+		}
+		if click.Variant != "" {
+			variants[click.Variant]++
+		}
+		return nil
+	})
+	if err != nil {
+		return models.LinkStats{}, err
+	}
+	stats.UniqueVisitors = len(visitors)
+	stats.TopReferrers = top(referrers)
+	stats.TopChannels = top(channels)
+	stats.TopBrowsers = top(browsers)
+	stats.TopOS = top(systems)
+	stats.TopDevices = top(devices)
+	stats.TopCountries = top(countries)
+	stats.TopCities = top(cities)
+	stats.TopVariants = top(variants)
+	return stats, nil
+}
+
+// top возвращает topLimit самых частых значений, при равенстве — по алфавиту.
+func top(counts map[string]int) []models.StatsCount {
+	result := make([]models.StatsCount, 0, len(counts))
+	for value, count := range counts {
+		result = append(result, models.StatsCount{Value: value, Count: count})
+	}
+	slices.SortFunc(result, func(a, b models.StatsCount) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Value, b.Value)
+	})
+	if len(result) > topLimit {
+		result = result[:topLimit]
+	}
+	return result
+}
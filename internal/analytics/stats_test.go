@@ -0,0 +1,162 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/thalq/url-service/internal/models"
+)
+
+func TestStats(t *testing.T) {
+	ctx := context.Background()
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	const (
+		firefox = "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0"
+		iphone  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1"
+		bot     = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+	)
+	store := NewMemoryStore()
+	assert.NoError(t, store.SaveClicks(ctx, []models.Click{
+		{Time: day.Add(time.Hour), ShortURL: "abc", IPHash: "v1", Referrer: "https://www.google.com/", UserAgent: firefox,
+			Country: "GB", City: "London", Variant: "a"},
+		{Time: day.Add(2 * time.Hour), ShortURL: "abc", IPHash: "v1", Referrer: "https://google.com/search", UserAgent: iphone,
+			Country: "GB", City: "London", Variant: "b"},
+		// записан без классификации, разбирается при чтении
+		{Time: day.Add(26 * time.Hour), ShortURL: "abc", IPHash: "v2", UserAgent: iphone, Country: "RU", Variant: "b"},
+		{Time: day.Add(27 * time.Hour), ShortURL: "abc", IPHash: "v5", UserAgent: bot, Variant: "a"},
+		{Time: day.Add(27 * time.Hour), ShortURL: "other", IPHash: "v3"},
+		{Time: day.Add(-time.Hour), ShortURL: "abc", IPHash: "v4"},
+	}))
+
+	stats, err := Stats(ctx, store, "abc", day, day.Add(72*time.Hour), IntervalDay)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, stats.TotalClicks)
+	assert.Equal(t, 1, stats.BotClicks)
+	assert.Equal(t, 2, stats.UniqueVisitors)
+	assert.Equal(t, []models.StatsPoint{
+		{Time: day, Clicks: 2},
+		{Time: day.Add(24 * time.Hour), Clicks: 2},
+		{Time: day.Add(48 * time.Hour), Clicks: 0},
+	}, stats.Series)
+	assert.Equal(t, []models.StatsCount{{Value: "google.com", Count: 2}}, stats.TopReferrers)
+	assert.Equal(t, []models.StatsCount{{Value: "search", Count: 2}, {Value: "direct", Count: 1}}, stats.TopChannels)
+	assert.Equal(t, []models.StatsCount{{Value: "Safari", Count: 2}, {Value: "Firefox", Count: 1}}, stats.TopBrowsers)
+	assert.Equal(t, []models.StatsCount{{Value: "iOS", Count: 2}, {Value: "Linux", Count: 1}}, stats.TopOS)
+	assert.Equal(t, []models.StatsCount{{Value: "mobile", Count: 2}, {Value: "desktop", Count: 1}}, stats.TopDevices)
+	assert.Equal(t, []models.StatsCount{{Value: "GB", Count: 2}, {Value: "RU", Count: 1}}, stats.TopCountries)
+	assert.Equal(t, []models.StatsCount{{Value: "London", Count: 2}}, stats.TopCities)
+	assert.Equal(t, []models.StatsCount{{Value: "b", Count: 2}, {Value: "a", Count: 1}}, stats.TopVariants)
+
+	stats, err = Stats(ctx, store, "abc", day, day.Add(3*time.Hour), IntervalHour)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 1}, []int{stats.Series[0].Clicks, stats.Series[1].Clicks, stats.Series[2].Clicks})
+
+	_, err = Stats(ctx, store, "abc", day, day, IntervalDay)
+	assert.ErrorIs(t, err, ErrInvalidRange)
+	_, err = Stats(ctx, store, "abc", day, day.Add(time.Hour), "week")
+	assert.ErrorIs(t, err, ErrInvalidRange)
+	_, err = Stats(ctx, store, "abc", day, day.AddDate(2, 0, 0), IntervalHour)
+	assert.ErrorIs(t, err, ErrInvalidRange)
+}
+
+func TestTopLimit(t *testing.T) {
+	counts := make(map[string]int)
+	for i := 0; i < 15; i++ {
+		counts[fmt.Sprintf("ref%02d", i)] = i
+	}
+	result := top(counts)
+	assert.Len(t, result, topLimit)
+	assert.Equal(t, models.StatsCount{Value: "ref14", Count: 14}, result[0])
+}
+
+func TestFileStoreScanClicks(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileStore(filepath.Join(t.TempDir(), "clicks.log"))
+	assert.NoError(t, err)
+	defer store.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	assert.NoError(t, store.SaveClicks(ctx, []models.Click{
+		{Time: now.Add(-time.Hour), ShortURL: "a"},
+		{Time: now, ShortURL: "a"},
+		{Time: now, ShortURL: "b"},
+	}))
+	// недописанная строка в конце файла пропускается
+	store.file.WriteString(`{"time":"`)
+
+	var got []models.Click
+	err = store.ScanClicks(ctx, Filter{ShortURLs: []string{"a"}, From: now}, func(click models.Click) error {
+		got = append(got, click)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Click{{Time: now, ShortURL: "a"}}, got)
+}
+
+func TestPostgresStoreScanClicks(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(anyArgs{}))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	from := time.Now().Add(-time.Hour)
+	mock.ExpectQuery(regexp.QuoteMeta("FROM clicks WHERE short_url = ANY($1) AND clicked_at >= $2 ORDER BY clicked_at")).
+		WithArgs([]string{"a"}, from).
+		WillReturnRows(sqlmock.NewRows([]string{"clicked_at", "short_url", "referrer", "user_agent", "ip_hash", "accept_language",
+			"browser", "os", "device", "is_bot", "source", "channel", "country", "region", "city", "variant"}).
+			AddRow(from, "a", "ref", "ua", "hash", "en", "Other", "Other", "desktop", false, "", "referral", "RU", "Moscow", "Moscow", "b"))
+
+	var got []models.Click
+	err = NewPostgresStore(db).ScanClicks(context.Background(), Filter{ShortURLs: []string{"a"}, From: from}, func(click models.Click) error {
+		got = append(got, click)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Click{{Time: from, ShortURL: "a", Referrer: "ref", UserAgent: "ua", IPHash: "hash", AcceptLanguage: "en",
+		Browser: "Other", OS: "Other", Device: "desktop", Channel: "referral", Country: "RU", Region: "Moscow", City: "Moscow", Variant: "b"}}, got)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteClicks(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	clicks := []models.Click{
+		{Time: now, ShortURL: "a"},
+		{Time: now, ShortURL: "b"},
+		{Time: now, ShortURL: "a"},
+	}
+	fileStore, err := NewFileStore(filepath.Join(t.TempDir(), "clicks.log"))
+	assert.NoError(t, err)
+	defer fileStore.Close()
+
+	for _, store := range []Store{NewMemoryStore(), fileStore} {
+		assert.NoError(t, store.SaveClicks(ctx, clicks))
+		assert.NoError(t, store.DeleteClicks(ctx, []string{"a"}))
+		// запись после удаления идёт в новый файл
+		assert.NoError(t, store.SaveClicks(ctx, []models.Click{{Time: now, ShortURL: "c"}}))
+
+		var got []string
+		err := store.ScanClicks(ctx, Filter{ShortURLs: []string{"a", "b", "c"}}, func(click models.Click) error {
+			got = append(got, click.ShortURL)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"b", "c"}, got)
+	}
+}
+
+func TestPostgresStoreDeleteClicks(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(anyArgs{}))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM clicks WHERE short_url = ANY($1)")).
+		WithArgs([]string{"a"}).WillReturnResult(sqlmock.NewResult(0, 2))
+	assert.NoError(t, NewPostgresStore(db).DeleteClicks(context.Background(), []string{"a"}))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,249 @@
+// Package analytics собирает переходы по коротким ссылкам.
+//
+// Recorder принимает переходы из обработчика редиректа без блокировки
+// и пачками сохраняет их в Store: таблицу clicks в Postgres, JSON-lines
+// файл рядом с файловым хранилищем или память.
+package analytics
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/thalq/url-service/internal/models"
+	"github.com/thalq/url-service/internal/operations"
+)
+
+// Store — хранилище переходов.
+type Store interface {
+	SaveClicks(ctx context.Context, clicks []models.Click) error
+	// ScanClicks по одному передаёт в fn переходы, подходящие под filter,
+	// не загружая их в память разом. Ошибка fn прерывает обход.
+	ScanClicks(ctx context.Context, filter Filter, fn func(models.Click) error) error
+	// DeleteClicks удаляет переходы по ссылкам shortURLs, когда сами
+	// ссылки удалены окончательно.
+	DeleteClicks(ctx context.Context, shortURLs []string) error
+}
+
+// Filter отбирает переходы по ссылкам ShortURLs за период [From, To).
+// Нулевые From и To не ограничивают период.
+type Filter struct {
+	ShortURLs []string
+	From      time.Time
+	To        time.Time
+}
+
+// matcher собирает проверку фильтра один раз на обход: выгрузка по всем
+// ссылкам пользователя иначе искала бы код перехода в списке на каждой строке.
+func (f Filter) matcher() func(models.Click) bool {
+	codes := codeSet(f.ShortURLs)
+	return func(click models.Click) bool {
+		if !f.From.IsZero() && click.Time.Before(f.From) {
+			return false
+		}
+		if !f.To.IsZero() && !click.Time.Before(f.To) {
+			return false
+		}
+		return codes[click.ShortURL]
+	}
+}
+
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) SaveClicks(ctx context.Context, clicks []models.Click) error {
+	return operations.InsertClicks(ctx, s.db, clicks)
+}
+
+func (s *PostgresStore) ScanClicks(ctx context.Context, filter Filter, fn func(models.Click) error) error {
+	return operations.ScanClicks(ctx, s.db, filter.ShortURLs, filter.From, filter.To, fn)
+}
+
+func (s *PostgresStore) DeleteClicks(ctx context.Context, shortURLs []string) error {
+	return operations.DeleteClicks(ctx, s.db, shortURLs)
+}
+
+// maxClickLine ограничивает длину строки файла переходов.
+const maxClickLine = 1024 * 1024
+
+// FileStore дописывает переходы в JSON-lines файл.
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+func NewFileStore(path string) (*FileStore, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStore{path: path, file: file}, nil
+}
+
+func (s *FileStore) SaveClicks(ctx context.Context, clicks []models.Click) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := bufio.NewWriter(s.file)
+	encoder := json.NewEncoder(w)
+	for i := range clicks {
+		if err := encoder.Encode(&clicks[i]); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// ScanClicks читает файл отдельным дескриптором, поэтому не мешает записи.
+// Строка, которую воркер дописывает прямо сейчас, может попасть в обход
+// обрезанной — такие строки пропускаются.
+func (s *FileStore) ScanClicks(ctx context.Context, filter Filter, fn func(models.Click) error) error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	match := filter.matcher()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxClickLine)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var click models.Click
+		if err := json.Unmarshal(scanner.Bytes(), &click); err != nil {
+			continue
+		}
+		if !match(click) {
+			continue
+		}
+		if err := fn(click); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// DeleteClicks переписывает файл без переходов по shortURLs: новый файл
+// пишется рядом и атомарно подменяет старый. Запись переходов на это
+// время ждёт.
+func (s *FileStore) DeleteClicks(ctx context.Context, shortURLs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	src, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	// tmp открыт на дозапись: после переименования он и станет файлом,
+	// в который пишет SaveClicks
+	file, err := os.OpenFile(tmp.Name(), os.O_WRONLY|os.O_APPEND, 0666)
+	tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	deleted := codeSet(shortURLs)
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxClickLine)
+	w := bufio.NewWriter(file)
+	for scanner.Scan() {
+		var click models.Click
+		if err := json.Unmarshal(scanner.Bytes(), &click); err == nil && deleted[click.ShortURL] {
+			continue
+		}
+		w.Write(scanner.Bytes())
+		w.WriteByte('\n')
+	}
+	err = errors.Join(scanner.Err(), w.Flush(), file.Sync())
+	if err == nil {
+		err = os.Rename(file.Name(), s.path)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file.Close()
+	s.file = file
+	return nil
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// MemoryStore хранит переходы в памяти процесса.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	clicks []models.Click
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) SaveClicks(ctx context.Context, clicks []models.Click) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clicks = append(s.clicks, clicks...)
+	return nil
+}
+
+func (s *MemoryStore) ScanClicks(ctx context.Context, filter Filter, fn func(models.Click) error) error {
+	match := filter.matcher()
+	for _, click := range s.Clicks() {
+		if !match(click) {
+			continue
+		}
+		if err := fn(click); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteClicks(ctx context.Context, shortURLs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deleted := codeSet(shortURLs)
+	s.clicks = slices.DeleteFunc(s.clicks, func(click models.Click) bool {
+		return deleted[click.ShortURL]
+	})
+	return nil
+}
+
+func codeSet(shortURLs []string) map[string]bool {
+	set := make(map[string]bool, len(shortURLs))
+	for _, shortURL := range shortURLs {
+		set[shortURL] = true
+	}
+	return set
+}
+
+// Clicks возвращает копию сохранённых переходов.
+func (s *MemoryStore) Clicks() []models.Click {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]models.Click(nil), s.clicks...)
+}
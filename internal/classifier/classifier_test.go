@@ -0,0 +1,105 @@
+package classifier
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		name string
+		ua   string
+		want Agent
+	}{
+		{
+			name: "chrome on windows",
+			ua:   "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+			want: Agent{Browser: "Chrome", OS: "Windows", Device: DeviceDesktop},
+		},
+		{
+			name: "edge on windows",
+			ua:   "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.2478.67",
+			want: Agent{Browser: "Edge", OS: "Windows", Device: DeviceDesktop},
+		},
+		{
+			name: "safari on iphone",
+			ua:   "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+			want: Agent{Browser: "Safari", OS: "iOS", Device: DeviceMobile},
+		},
+		{
+			name: "safari on ipad",
+			ua:   "Mozilla/5.0 (iPad; CPU OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+			want: Agent{Browser: "Safari", OS: "iOS", Device: DeviceTablet},
+		},
+		{
+			name: "chrome on android phone",
+			ua:   "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Mobile Safari/537.36",
+			want: Agent{Browser: "Chrome", OS: "Android", Device: DeviceMobile},
+		},
+		{
+			name: "samsung on android tablet",
+			ua:   "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/24.0 Chrome/117.0.0.0 Safari/537.36",
+			want: Agent{Browser: "Samsung Internet", OS: "Android", Device: DeviceTablet},
+		},
+		{
+			name: "firefox on linux",
+			ua:   "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0",
+			want: Agent{Browser: "Firefox", OS: "Linux", Device: DeviceDesktop},
+		},
+		{
+			name: "yandex on macos",
+			ua:   "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/122.0.0.0 YaBrowser/24.4.0.0 Safari/537.36",
+			want: Agent{Browser: "Yandex Browser", OS: "macOS", Device: DeviceDesktop},
+		},
+		{
+			name: "googlebot",
+			ua:   "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			want: Agent{Browser: Other, OS: Other, Device: DeviceOther, Bot: true},
+		},
+		{
+			name: "telegram preview",
+			ua:   "TelegramBot (like TwitterBot)",
+			want: Agent{Browser: Other, OS: Other, Device: DeviceOther, Bot: true},
+		},
+		{
+			name: "curl",
+			ua:   "curl/8.5.0",
+			want: Agent{Browser: Other, OS: Other, Device: DeviceOther, Bot: true},
+		},
+		{
+			name: "empty",
+			want: Agent{Browser: Other, OS: Other, Device: DeviceOther},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ParseUserAgent(tt.ua))
+		})
+	}
+}
+
+func TestParseReferrer(t *testing.T) {
+	tests := []struct {
+		referrer string
+		want     Source
+	}{
+		{"", Source{Channel: ChannelDirect}},
+		{"https://www.google.com/", Source{Domain: "google.com", Channel: ChannelSearch}},
+		{"https://www.google.co.uk/search?q=x", Source{Domain: "google.co.uk", Channel: ChannelSearch}},
+		{"https://yandex.ru/search/?text=x", Source{Domain: "yandex.ru", Channel: ChannelSearch}},
+		{"https://ya.ru/", Source{Domain: "ya.ru", Channel: ChannelSearch}},
+		{"https://mail.google.com/mail/u/0/", Source{Domain: "mail.google.com", Channel: ChannelEmail}},
+		{"https://e.mail.ru/inbox/", Source{Domain: "e.mail.ru", Channel: ChannelEmail}},
+		{"https://t.co/abc", Source{Domain: "t.co", Channel: ChannelSocial}},
+		{"https://m.facebook.com/", Source{Domain: "m.facebook.com", Channel: ChannelSocial}},
+		{"https://lh3.googleusercontent.com/x", Source{Domain: "lh3.googleusercontent.com", Channel: ChannelReferral}},
+		{"https://WWW.Example.COM./page", Source{Domain: "example.com", Channel: ChannelReferral}},
+		{"not a url", Source{Channel: ChannelReferral}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.referrer, func(t *testing.T) {
+			assert.Equal(t, tt.want, ParseReferrer(tt.referrer))
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package classifier
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Каналы, по которым пришёл переход.
+const (
+	ChannelDirect   = "direct"
+	ChannelSearch   = "search"
+	ChannelSocial   = "social"
+	ChannelEmail    = "email"
+	ChannelReferral = "referral"
+)
+
+// Source — источник перехода: домен Referer без «www.» и канал.
+type Source struct {
+	Domain  string
+	Channel string
+}
+
+// emailDomains — веб-почта. Проверяется раньше поиска: mail.google.com
+// и mail.yandex.ru иначе попали бы в поиск.
+var emailDomains = []string{
+	"mail.google.com", "outlook.live.com", "outlook.office.com", "outlook.office365.com",
+	"mail.yandex.ru", "mail.yandex.com", "e.mail.ru", "mail.yahoo.com", "mail.proton.me",
+}
+
+// searchBrands — поисковики с доменами во многих зонах (google.de, yandex.kz).
+var searchBrands = []string{"google", "yandex", "bing", "duckduckgo", "yahoo", "baidu", "ecosia", "startpage"}
+
+var searchDomains = []string{"ya.ru", "search.brave.com", "go.mail.ru"}
+
+var socialDomains = []string{
+	"facebook.com", "fb.com", "instagram.com", "t.co", "twitter.com", "x.com",
+	"linkedin.com", "lnkd.in", "vk.com", "vk.ru", "ok.ru", "reddit.com", "t.me",
+	"telegram.org", "youtube.com", "youtu.be", "tiktok.com", "pinterest.com",
+	"wa.me", "whatsapp.com", "threads.net", "dzen.ru",
+}
+
+// ParseReferrer определяет домен и канал по Referer. Пустой Referer —
+// прямой переход, нераспознанный — ChannelReferral с пустым доменом.
+func ParseReferrer(referrer string) Source {
+	if referrer == "" {
+		return Source{Channel: ChannelDirect}
+	}
+	u, err := url.Parse(referrer)
+	if err != nil || u.Hostname() == "" {
+		return Source{Channel: ChannelReferral}
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	domain := strings.TrimPrefix(host, "www.")
+	switch {
+	case matchDomain(host, emailDomains):
+		return Source{Domain: domain, Channel: ChannelEmail}
+	case matchDomain(host, searchDomains), matchBrand(host, searchBrands):
+		return Source{Domain: domain, Channel: ChannelSearch}
+	case matchDomain(host, socialDomains):
+		return Source{Domain: domain, Channel: ChannelSocial}
+	}
+	return Source{Domain: domain, Channel: ChannelReferral}
+}
+
+// matchDomain проверяет, что host — один из domains или его поддомен.
+func matchDomain(host string, domains []string) bool {
+	for _, d := range domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchBrand проверяет, что в host есть метка brand перед зоной:
+// google.com, www.google.co.uk, но не googleusercontent.com.
+func matchBrand(host string, brands []string) bool {
+	labels := strings.Split(host, ".")
+	for i, label := range labels[:len(labels)-1] {
+		for _, brand := range brands {
+			if label != brand {
+				continue
+			}
+			// после бренда может идти только зона: com, de или co.uk
+			rest := labels[i+1:]
+			if len(rest) == 1 || (len(rest) == 2 && len(rest[1]) == 2) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+// Package classifier разбирает User-Agent и Referer переходов на значения,
+// пригодные для отчётов: семейство браузера, ОС, тип устройства, признак
+// бота, домен источника и канал.
+package classifier
+
+import "strings"
+
+// Типы устройств.
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceOther   = "other"
+)
+
+// Other — значение для нераспознанных браузеров и ОС.
+const Other = "Other"
+
+// Agent — результат разбора User-Agent.
+type Agent struct {
+	Browser string
+	OS      string
+	Device  string
+	Bot     bool
+}
+
+// rule сопоставляет значение первой найденной в User-Agent подстроке.
+type rule struct {
+	value   string
+	markers []string
+}
+
+// botMarkers — признаки краулеров, превью мессенджеров и HTTP-клиентов.
+// Сравниваются с User-Agent в нижнем регистре.
+var botMarkers = []string{
+	"bot", "crawler", "spider", "slurp", "crawl", "headlesschrome", "lighthouse",
+	"facebookexternalhit", "embedly", "preview", "curl/", "wget/", "python-requests",
+	"python-urllib", "go-http-client", "okhttp", "java/", "httpclient", "axios/", "node-fetch",
+}
+
+// browserRules проверяются по порядку: многие браузеры добавляют в
+// User-Agent чужие маркеры, например Edge и Opera — «Chrome/» и «Safari/».
+var browserRules = []rule{
+	{"Edge", []string{"Edg/", "Edge/", "EdgA/", "EdgiOS/"}},
+	{"Opera", []string{"OPR/", "Opera", "OPiOS/"}},
+	{"Yandex Browser", []string{"YaBrowser/"}},
+	{"Samsung Internet", []string{"SamsungBrowser/"}},
+	{"Firefox", []string{"Firefox/", "FxiOS/"}},
+	{"Chrome", []string{"Chrome/", "CriOS/", "Chromium/"}},
+	{"Safari", []string{"Safari/"}},
+	{"Internet Explorer", []string{"MSIE ", "Trident/"}},
+}
+
+// osRules: iOS раньше macOS — iPad пишет «like Mac OS X», Android раньше
+// Linux — в его User-Agent есть «Linux».
+var osRules = []rule{
+	{"iOS", []string{"iPhone", "iPad", "iPod"}},
+	{"Android", []string{"Android"}},
+	{"Windows", []string{"Windows"}},
+	{"macOS", []string{"Macintosh", "Mac OS X"}},
+	{"Chrome OS", []string{"CrOS"}},
+	{"Linux", []string{"Linux"}},
+}
+
+func match(ua string, rules []rule) string {
+	for _, r := range rules {
+		for _, marker := range r.markers {
+			if strings.Contains(ua, marker) {
+				return r.value
+			}
+		}
+	}
+	return Other
+}
+
+// ParseUserAgent разбирает User-Agent. Пустая строка даёт Other и DeviceOther.
+func ParseUserAgent(ua string) Agent {
+	if ua == "" {
+		return Agent{Browser: Other, OS: Other, Device: DeviceOther}
+	}
+	agent := Agent{
+		Browser: match(ua, browserRules),
+		OS:      match(ua, osRules),
+		Device:  device(ua),
+	}
+	lower := strings.ToLower(ua)
+	for _, marker := range botMarkers {
+		if strings.Contains(lower, marker) {
+			agent.Bot = true
+			agent.Device = DeviceOther
+			break
+		}
+	}
+	return agent
+}
+
+func device(ua string) string {
+	switch {
+	case strings.Contains(ua, "iPad"), strings.Contains(ua, "Tablet"),
+		strings.Contains(ua, "Android") && !strings.Contains(ua, "Mobile"):
+		return DeviceTablet
+	case strings.Contains(ua, "Mobi"), strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPod"):
+		return DeviceMobile
+	}
+	return DeviceDesktop
+}
@@ -1,7 +1,9 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/thalq/url-service/config"
@@ -17,12 +19,12 @@ func DBConnect(cfg config.Config) *sql.DB {
 	}
 	logger.Sugar.Info("Connected to database")
 
-	_, err = db.Exec("CREATE TABLE IF NOT EXISTS urls (original_url TEXT PRIMARY KEY, short_url TEXT, correlation_id TEXT, user_id TEXT, is_deleted BOOL DEFAULT False)")
-
-	if err != nil {
-		logger.Sugar.Error("Failed to create table")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := Migrate(ctx, db); err != nil {
+		logger.Sugar.Errorf("Failed to migrate database: %v", err)
 		return nil
 	}
-	logger.Sugar.Info("Table created")
+	logger.Sugar.Info("Database migrated")
 	return db
 }
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS urls (
+    original_url TEXT PRIMARY KEY,
+    short_url TEXT,
+    correlation_id TEXT,
+    user_id TEXT,
+    is_deleted BOOL DEFAULT False
+);
@@ -0,0 +1,3 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE urls ADD CONSTRAINT urls_short_url_key UNIQUE (short_url);
+CREATE INDEX IF NOT EXISTS urls_user_id_idx ON urls (user_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS urls_alias_key;
+ALTER TABLE urls DROP COLUMN IF EXISTS alias;
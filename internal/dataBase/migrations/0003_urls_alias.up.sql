@@ -0,0 +1,2 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS alias TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS urls_alias_key ON urls (alias);
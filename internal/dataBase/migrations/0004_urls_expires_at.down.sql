@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS urls_expires_at_idx;
+ALTER TABLE urls DROP COLUMN IF EXISTS expires_at;
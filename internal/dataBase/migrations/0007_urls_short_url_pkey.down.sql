@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS url_history;
+ALTER TABLE urls DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE urls DROP CONSTRAINT urls_pkey;
+ALTER TABLE urls ADD CONSTRAINT urls_short_url_key UNIQUE (short_url);
+ALTER TABLE urls DROP CONSTRAINT urls_original_url_key;
+ALTER TABLE urls ADD CONSTRAINT urls_pkey PRIMARY KEY (original_url);
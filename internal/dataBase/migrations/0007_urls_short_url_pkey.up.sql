@@ -0,0 +1,12 @@
+ALTER TABLE urls DROP CONSTRAINT urls_pkey;
+ALTER TABLE urls ADD CONSTRAINT urls_original_url_key UNIQUE (original_url);
+ALTER TABLE urls DROP CONSTRAINT urls_short_url_key;
+ALTER TABLE urls ADD CONSTRAINT urls_pkey PRIMARY KEY (short_url);
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ;
+CREATE TABLE IF NOT EXISTS url_history (
+    id BIGSERIAL PRIMARY KEY,
+    short_url TEXT NOT NULL REFERENCES urls (short_url) ON DELETE CASCADE,
+    original_url TEXT NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS url_history_short_url_idx ON url_history (short_url);
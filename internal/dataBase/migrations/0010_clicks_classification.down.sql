@@ -0,0 +1,6 @@
+ALTER TABLE clicks DROP COLUMN IF EXISTS channel;
+ALTER TABLE clicks DROP COLUMN IF EXISTS source;
+ALTER TABLE clicks DROP COLUMN IF EXISTS is_bot;
+ALTER TABLE clicks DROP COLUMN IF EXISTS device;
+ALTER TABLE clicks DROP COLUMN IF EXISTS os;
+ALTER TABLE clicks DROP COLUMN IF EXISTS browser;
@@ -0,0 +1,96 @@
+package deletion
+
+import (
+	"time"
+
+	"github.com/thalq/url-service/internal/models"
+)
+
+// Статусы кодов в задании на удаление.
+const (
+	StatusPending   = "pending"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	// StatusNotFound — у пользователя нет ссылки с таким кодом.
+	StatusNotFound = "not_found"
+)
+
+// job — задание, созданное одним вызовом Enqueue. Хранится в памяти: после
+// перезапуска задания восстанавливаются из журнала только для кодов, которые
+// ещё ждали сброса.
+type job struct {
+	userID   string
+	urls     map[string]string
+	pending  int
+	finished time.Time
+}
+
+// addJob регистрирует задание. Вызывается под q.mu.
+func (q *Queue) addJob(id, userID string, shortURLs []string, now time.Time) {
+	j, ok := q.jobs[id]
+	if !ok {
+		j = &job{userID: userID, urls: make(map[string]string)}
+		q.jobs[id] = j
+	}
+	for _, shortURL := range shortURLs {
+		if _, ok := j.urls[shortURL]; !ok {
+			j.urls[shortURL] = StatusPending
+			j.pending++
+		}
+	}
+	if j.pending == 0 {
+		j.finished = now
+	}
+}
+
+// settle фиксирует итог сброса кода. Вызывается под q.mu.
+func (q *Queue) settle(item models.ChDelete, status string, now time.Time) {
+	j, ok := q.jobs[item.JobID]
+	if !ok || j.urls[item.ShortURL] != StatusPending {
+		return
+	}
+	j.urls[item.ShortURL] = status
+	j.pending--
+	if j.pending == 0 {
+		j.finished = now
+	}
+}
+
+// pruneJobs забывает задания, завершённые раньше JobRetention. Вызывается под q.mu.
+func (q *Queue) pruneJobs(now time.Time) {
+	for id, j := range q.jobs {
+		if j.pending == 0 && now.Sub(j.finished) > q.opts.JobRetention {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+func (q *Queue) jobData(id string) models.DeletionJob {
+	j := q.jobs[id]
+	data := models.DeletionJob{ID: id, URLs: make(map[string]string, len(j.urls))}
+	for shortURL, status := range j.urls {
+		data.URLs[shortURL] = status
+		switch status {
+		case StatusPending:
+			data.Pending++
+		case StatusCompleted:
+			data.Completed++
+		case StatusFailed:
+			data.Failed++
+		case StatusNotFound:
+			data.NotFound++
+		}
+	}
+	return data
+}
+
+// Job возвращает состояние задания. Чужие и забытые задания не находятся.
+func (q *Queue) Job(userID, id string) (models.DeletionJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok || j.userID != userID {
+		return models.DeletionJob{}, false
+	}
+	return q.jobData(id), true
+}
@@ -0,0 +1,357 @@
+// Package deletion — очередь фонового удаления ссылок.
+//
+// Запросы на удаление сначала дописываются в журнал на диске, поэтому
+// переживают обрыв соединения клиента и перезапуск процесса. Фоновый
+// воркер копит их и сбрасывает в хранилище пачками: одним вызовом
+// DeleteURLs на пользователя, когда набралось BatchSize кодов или прошёл
+// FlushInterval. Неудачные пачки остаются в очереди и повторяются
+// с растущей задержкой, пока у кода не кончатся MaxAttempts попыток.
+// Каждый вызов Enqueue — отдельное задание, его состояние можно узнать
+// через Job. Удаление идемпотентно, поэтому повторный сброс
+// уже удалённых ссылок после перезапуска безопасен.
+package deletion
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	logger "github.com/thalq/url-service/internal/middleware"
+	"github.com/thalq/url-service/internal/models"
+)
+
+// Deleter — хранилище, в котором очередь помечает ссылки удалёнными.
+// DeleteURLs возвращает коды, которые принадлежат пользователю и удалены.
+type Deleter interface {
+	DeleteURLs(ctx context.Context, userID string, shortURLs []string) ([]string, error)
+}
+
+type Options struct {
+	// JournalPath — файл журнала. Пустой путь — очередь только в памяти.
+	JournalPath   string
+	BatchSize     int
+	FlushInterval time.Duration
+	// MaxRetryDelay ограничивает задержку между повторами неудачного сброса.
+	MaxRetryDelay time.Duration
+	// MaxAttempts — число неудачных сбросов, после которого код считается
+	// неудалённым и убирается из очереди.
+	MaxAttempts int
+	// JobRetention — сколько помнить завершённые задания.
+	JobRetention time.Duration
+}
+
+const (
+	defaultBatchSize     = 100
+	defaultFlushInterval = time.Second
+	defaultMaxRetryDelay = 5 * time.Minute
+	defaultMaxAttempts   = 10
+	defaultJobRetention  = 24 * time.Hour
+	flushTimeout         = 30 * time.Second
+)
+
+type Queue struct {
+	store Deleter
+	opts  Options
+
+	mu      sync.Mutex
+	pending []models.ChDelete
+	jobs    map[string]*job
+	journal *os.File
+
+	// flushMu не даёт двум сбросам идти одновременно
+	flushMu sync.Mutex
+	full    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// New создаёт очередь и загружает из журнала запросы, не сброшенные
+// до прошлой остановки. Воркер запускается методом Start.
+func New(store Deleter, opts Options) (*Queue, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultFlushInterval
+	}
+	if opts.MaxRetryDelay <= 0 {
+		opts.MaxRetryDelay = defaultMaxRetryDelay
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultMaxAttempts
+	}
+	if opts.JobRetention <= 0 {
+		opts.JobRetention = defaultJobRetention
+	}
+	q := &Queue{
+		store: store,
+		opts:  opts,
+		jobs:  make(map[string]*job),
+		full:  make(chan struct{}, 1),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	if opts.JournalPath == "" {
+		return q, nil
+	}
+	pending, err := readJournal(opts.JournalPath)
+	if err != nil {
+		return nil, err
+	}
+	q.pending = pending
+	now := time.Now()
+	for _, item := range pending {
+		if item.JobID != "" {
+			q.addJob(item.JobID, item.UserID, []string{item.ShortURL}, now)
+		}
+	}
+	if q.journal, err = os.OpenFile(opts.JournalPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666); err != nil {
+		return nil, err
+	}
+	if len(pending) > 0 {
+		logger.Sugar.Infof("Loaded %d pending deletions from %s", len(pending), opts.JournalPath)
+	}
+	return q, nil
+}
+
+func readJournal(path string) ([]models.ChDelete, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var pending []models.ChDelete
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var item models.ChDelete
+		err := decoder.Decode(&item)
+		if errors.Is(err, io.EOF) {
+			return pending, nil
+		}
+		if err != nil {
+			// хвост, недописанный при падении, отбрасываем
+			logger.Sugar.Errorf("Failed to read deletion journal %s: %v", path, err)
+			return pending, nil
+		}
+		pending = append(pending, item)
+	}
+}
+
+// Enqueue ставит ссылки пользователя в очередь на удаление и возвращает
+// созданное задание. После возврата без ошибки запрос записан в журнал
+// и не потеряется при перезапуске.
+func (q *Queue) Enqueue(userID string, shortURLs []string) (models.DeletionJob, error) {
+	id := uuid.New().String()
+	items := make([]models.ChDelete, 0, len(shortURLs))
+	for _, shortURL := range shortURLs {
+		items = append(items, models.ChDelete{UserID: userID, ShortURL: shortURL, JobID: id})
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.journal != nil {
+		if err := appendJournal(q.journal, items); err != nil {
+			return models.DeletionJob{}, err
+		}
+	}
+	q.addJob(id, userID, shortURLs, time.Now())
+	q.pending = append(q.pending, items...)
+	if len(q.pending) >= q.opts.BatchSize {
+		select {
+		case q.full <- struct{}{}:
+		default:
+		}
+	}
+	return q.jobData(id), nil
+}
+
+func appendJournal(file *os.File, items []models.ChDelete) error {
+	w := bufio.NewWriter(file)
+	encoder := json.NewEncoder(w)
+	for i := range items {
+		if err := encoder.Encode(&items[i]); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// Pending возвращает число запросов, ещё не сброшенных в хранилище.
+func (q *Queue) Pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// Flush сбрасывает накопленные запросы в хранилище, по одному вызову
+// DeleteURLs на пользователя. Запросы пользователей, для которых вызов
+// не удался, остаются в очереди. Коды, которых хранилище не удалило,
+// потому что у пользователя таких нет, получают статус not_found.
+func (q *Queue) Flush(ctx context.Context) error {
+	q.flushMu.Lock()
+	defer q.flushMu.Unlock()
+
+	q.mu.Lock()
+	batch := q.pending
+	q.pending = nil
+	if len(batch) == 0 {
+		q.pruneJobs(time.Now())
+	}
+	q.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	var users []string
+	byUser := make(map[string][]models.ChDelete)
+	for _, item := range batch {
+		if _, ok := byUser[item.UserID]; !ok {
+			users = append(users, item.UserID)
+		}
+		byUser[item.UserID] = append(byUser[item.UserID], item)
+	}
+	results := make(map[string]error, len(users))
+	deleted := make(map[string]map[string]bool, len(users))
+	var flushErr error
+	for _, userID := range users {
+		items := byUser[userID]
+		shortURLs := make([]string, 0, len(items))
+		for _, item := range items {
+			shortURLs = append(shortURLs, item.ShortURL)
+		}
+		codes, err := q.store.DeleteURLs(ctx, userID, shortURLs)
+		results[userID] = err
+		if err != nil {
+			logger.Sugar.Errorf("Failed to delete %d URLs of user %s: %v", len(shortURLs), userID, err)
+			flushErr = errors.Join(flushErr, err)
+			continue
+		}
+		deleted[userID] = make(map[string]bool, len(codes))
+		for _, code := range codes {
+			deleted[userID][code] = true
+		}
+		logger.Sugar.Infof("Deleted %d of %d URLs of user %s", len(codes), len(shortURLs), userID)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	var failed []models.ChDelete
+	for _, userID := range users {
+		for _, item := range byUser[userID] {
+			if results[userID] == nil {
+				status := StatusCompleted
+				if !deleted[userID][item.ShortURL] {
+					status = StatusNotFound
+				}
+				q.settle(item, status, now)
+				continue
+			}
+			item.Attempts++
+			if item.Attempts >= q.opts.MaxAttempts {
+				logger.Sugar.Errorf("Giving up deleting URL %s of user %s after %d attempts", item.ShortURL, userID, item.Attempts)
+				q.settle(item, StatusFailed, now)
+				continue
+			}
+			failed = append(failed, item)
+		}
+	}
+	q.pruneJobs(now)
+	q.pending = append(failed, q.pending...)
+	if q.journal != nil {
+		if err := q.rewriteJournal(); err != nil {
+			// в журнале остались уже удалённые ссылки, после перезапуска
+			// они удалятся повторно
+			logger.Sugar.Errorf("Failed to rewrite deletion journal: %v", err)
+		}
+	}
+	return flushErr
+}
+
+// rewriteJournal заменяет журнал оставшимися запросами. Вызывается под q.mu.
+func (q *Queue) rewriteJournal() error {
+	path := q.opts.JournalPath
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := appendJournal(tmp, q.pending); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	q.journal.Close()
+	q.journal, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	return err
+}
+
+// Start запускает воркер, который сбрасывает очередь по заполнению пачки
+// или по таймеру.
+func (q *Queue) Start() {
+	go q.run()
+}
+
+func (q *Queue) run() {
+	defer close(q.done)
+	ticker := time.NewTicker(q.opts.FlushInterval)
+	defer ticker.Stop()
+
+	var retryDelay time.Duration
+	var retryAt time.Time
+	for {
+		select {
+		case <-q.stop:
+			ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+			q.Flush(ctx)
+			cancel()
+			return
+		case <-q.full:
+		case <-ticker.C:
+		}
+		if time.Now().Before(retryAt) {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+		err := q.Flush(ctx)
+		cancel()
+		if err == nil {
+			retryDelay = 0
+			continue
+		}
+		retryDelay = min(max(2*retryDelay, q.opts.FlushInterval), q.opts.MaxRetryDelay)
+		retryAt = time.Now().Add(retryDelay)
+	}
+}
+
+// Close останавливает воркер, последний раз сбрасывая очередь, и закрывает
+// журнал. Несброшенное останется в журнале до следующего запуска.
+func (q *Queue) Close() error {
+	close(q.stop)
+	<-q.done
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.journal == nil {
+		return nil
+	}
+	return q.journal.Close()
+}
@@ -0,0 +1,197 @@
+package deletion
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	logger "github.com/thalq/url-service/internal/middleware"
+	"github.com/thalq/url-service/internal/models"
+)
+
+type deleteCall struct {
+	userID    string
+	shortURLs []string
+}
+
+type fakeDeleter struct {
+	mu    sync.Mutex
+	calls []deleteCall
+	fail  map[string]bool
+	// missing — коды, которых нет у пользователя
+	missing map[string]bool
+}
+
+func (d *fakeDeleter) DeleteURLs(ctx context.Context, userID string, shortURLs []string) ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.fail[userID] {
+		return nil, errors.New("storage unavailable")
+	}
+	d.calls = append(d.calls, deleteCall{userID, shortURLs})
+	var deleted []string
+	for _, shortURL := range shortURLs {
+		if !d.missing[shortURL] {
+			deleted = append(deleted, shortURL)
+		}
+	}
+	return deleted, nil
+}
+
+func (d *fakeDeleter) setFail(userID string, fail bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.fail[userID] = fail
+}
+
+func (d *fakeDeleter) snapshot() []deleteCall {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]deleteCall(nil), d.calls...)
+}
+
+func TestQueueFlushGroupsByUser(t *testing.T) {
+	logger.InitLogger()
+	store := &fakeDeleter{fail: map[string]bool{}}
+	q, err := New(store, Options{})
+	assert.NoError(t, err)
+
+	_, err = q.Enqueue("user1", []string{"a", "b"})
+	assert.NoError(t, err)
+	_, err = q.Enqueue("user2", []string{"c"})
+	assert.NoError(t, err)
+	_, err = q.Enqueue("user1", []string{"d"})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, q.Pending())
+
+	assert.NoError(t, q.Flush(context.Background()))
+	assert.Equal(t, []deleteCall{
+		{"user1", []string{"a", "b", "d"}},
+		{"user2", []string{"c"}},
+	}, store.snapshot())
+	assert.Equal(t, 0, q.Pending())
+}
+
+func TestQueueKeepsFailedItems(t *testing.T) {
+	logger.InitLogger()
+	store := &fakeDeleter{fail: map[string]bool{"user2": true}}
+	q, err := New(store, Options{})
+	assert.NoError(t, err)
+
+	_, err = q.Enqueue("user1", []string{"a"})
+	assert.NoError(t, err)
+	_, err = q.Enqueue("user2", []string{"b"})
+	assert.NoError(t, err)
+	assert.Error(t, q.Flush(context.Background()))
+	assert.Equal(t, 1, q.Pending())
+
+	store.setFail("user2", false)
+	assert.NoError(t, q.Flush(context.Background()))
+	assert.Equal(t, []deleteCall{
+		{"user1", []string{"a"}},
+		{"user2", []string{"b"}},
+	}, store.snapshot())
+}
+
+func TestQueueReplaysJournal(t *testing.T) {
+	logger.InitLogger()
+	path := filepath.Join(t.TempDir(), "delete_queue.log")
+	store := &fakeDeleter{fail: map[string]bool{"user1": true}}
+
+	q, err := New(store, Options{JournalPath: path})
+	assert.NoError(t, err)
+	_, err = q.Enqueue("user1", []string{"a", "b"})
+	assert.NoError(t, err)
+	_, err = q.Enqueue("user2", []string{"c"})
+	assert.NoError(t, err)
+	assert.Error(t, q.Flush(context.Background()))
+	assert.NoError(t, q.journal.Close())
+
+	// после перезапуска в журнале только несброшенные запросы
+	store.setFail("user1", false)
+	q, err = New(store, Options{JournalPath: path})
+	assert.NoError(t, err)
+	defer q.journal.Close()
+	assert.Equal(t, 2, q.Pending())
+
+	assert.NoError(t, q.Flush(context.Background()))
+	assert.Equal(t, []deleteCall{
+		{"user2", []string{"c"}},
+		{"user1", []string{"a", "b"}},
+	}, store.snapshot())
+
+	q, err = New(store, Options{JournalPath: path})
+	assert.NoError(t, err)
+	defer q.journal.Close()
+	assert.Equal(t, 0, q.Pending())
+}
+
+func TestQueueWorkerFlushesFullBatch(t *testing.T) {
+	logger.InitLogger()
+	store := &fakeDeleter{fail: map[string]bool{}}
+	q, err := New(store, Options{BatchSize: 2, FlushInterval: time.Hour})
+	assert.NoError(t, err)
+	q.Start()
+
+	_, err = q.Enqueue("user1", []string{"a", "b"})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return len(store.snapshot()) == 1 }, time.Second, 10*time.Millisecond)
+
+	// остаток сбрасывается при остановке
+	_, err = q.Enqueue("user1", []string{"c"})
+	assert.NoError(t, err)
+	assert.NoError(t, q.Close())
+	assert.Equal(t, []deleteCall{
+		{"user1", []string{"a", "b"}},
+		{"user1", []string{"c"}},
+	}, store.snapshot())
+}
+
+func TestQueueJobs(t *testing.T) {
+	logger.InitLogger()
+	path := filepath.Join(t.TempDir(), "delete_queue.log")
+	store := &fakeDeleter{fail: map[string]bool{"user2": true}, missing: map[string]bool{"other": true}}
+	q, err := New(store, Options{JournalPath: path, MaxAttempts: 2})
+	assert.NoError(t, err)
+
+	first, err := q.Enqueue("user1", []string{"a", "b", "a", "other"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, first.Pending)
+	assert.Equal(t, map[string]string{"a": StatusPending, "b": StatusPending, "other": StatusPending}, first.URLs)
+	second, err := q.Enqueue("user2", []string{"c"})
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.ID, second.ID)
+
+	_, ok := q.Job("user2", first.ID)
+	assert.False(t, ok, "чужое задание не должно находиться")
+
+	assert.Error(t, q.Flush(context.Background()))
+	job, ok := q.Job("user1", first.ID)
+	assert.True(t, ok)
+	assert.Equal(t, 2, job.Completed)
+	// чужой или несуществующий код не считается удалённым
+	assert.Equal(t, 1, job.NotFound)
+	assert.Equal(t, StatusNotFound, job.URLs["other"])
+	job, _ = q.Job("user2", second.ID)
+	assert.Equal(t, 1, job.Pending)
+
+	// после перезапуска незавершённое задание восстанавливается из журнала
+	assert.NoError(t, q.journal.Close())
+	q, err = New(store, Options{JournalPath: path, MaxAttempts: 2})
+	assert.NoError(t, err)
+	defer q.journal.Close()
+	_, ok = q.Job("user1", first.ID)
+	assert.False(t, ok)
+	job, ok = q.Job("user2", second.ID)
+	assert.True(t, ok)
+	assert.Equal(t, 1, job.Pending)
+
+	assert.Error(t, q.Flush(context.Background()))
+	job, _ = q.Job("user2", second.ID)
+	assert.Equal(t, models.DeletionJob{ID: second.ID, Failed: 1, URLs: map[string]string{"c": StatusFailed}}, job)
+	assert.Equal(t, 0, q.Pending())
+}
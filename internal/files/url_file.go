@@ -3,14 +3,74 @@ package files
 import (
 	"bufio"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"io"
 	"os"
+	"time"
 
-	"github.com/thalq/url-service/config"
-	logger "github.com/thalq/url-service/internal/middleware"
 	"github.com/thalq/url-service/internal/models"
 )
 
+// NewTombstone возвращает запись об удалении ссылки её владельцем.
+// В файле она дописывается после исходной записи и помечает её удалённой.
+func NewTombstone(userID, shortURL string, at time.Time) *models.URLData {
+	return &models.URLData{
+		ShortURL:    shortURL,
+		UserID:      userID,
+		DeletedFlag: true,
+		DeletedAt:   &at,
+	}
+}
+
+func IsTombstone(data *models.URLData) bool {
+	return data.DeletedFlag && data.OriginalURL == ""
+}
+
+// NewUpdateRecord возвращает запись о смене исходного URL ссылки. От записи
+// новой ссылки она отличается заполненным updated_at и тем, что её код уже
+// встречался в файле раньше.
+func NewUpdateRecord(userID, shortURL, originalURL string, at time.Time) *models.URLData {
+	return &models.URLData{
+		OriginalURL: originalURL,
+		ShortURL:    shortURL,
+		UserID:      userID,
+		UpdatedAt:   &at,
+	}
+}
+
+// NewClickRecord возвращает запись с новым значением счётчика переходов
+// по ссылке. При чтении файла побеждает последняя такая запись.
+func NewClickRecord(shortURL string, clicks int) *models.URLData {
+	return &models.URLData{
+		ShortURL: shortURL,
+		Clicks:   clicks,
+	}
+}
+
+func IsClickRecord(data *models.URLData) bool {
+	return !data.DeletedFlag && data.OriginalURL == "" && data.UpdatedAt == nil
+}
+
+// NewRestoreRecord возвращает запись о восстановлении удалённой ссылки
+// её владельцем. Она снимает действие предыдущего tombstone.
+func NewRestoreRecord(userID, shortURL string, at time.Time) *models.URLData {
+	return &models.URLData{
+		ShortURL:  shortURL,
+		UserID:    userID,
+		UpdatedAt: &at,
+	}
+}
+
+func IsRestoreRecord(data *models.URLData) bool {
+	return !data.DeletedFlag && data.OriginalURL == "" && data.UpdatedAt != nil
+}
+
+// MaxRecordSize ограничивает длину одной записи в файле. Producer не пишет
+// записи длиннее, Consumer читает строки до этой длины.
+const MaxRecordSize = 1 << 20
+
+var ErrRecordTooLarge = errors.New("record too large")
+
 type Producer struct {
 	file   *os.File
 	writer *bufio.Writer
@@ -28,18 +88,51 @@ func NewProducer(filename string) (*Producer, error) {
 }
 
 func (p *Producer) WriteEvent(URLData *models.URLData) error {
+	if err := p.write(URLData); err != nil {
+		return err
+	}
+	return p.writer.Flush()
+}
+
+// WriteEvents пишет записи через буфер и сбрасывает его один раз в конце.
+func (p *Producer) WriteEvents(URLData []models.URLData) error {
+	for i := range URLData {
+		if err := p.write(&URLData[i]); err != nil {
+			return err
+		}
+	}
+	return p.writer.Flush()
+}
+
+func (p *Producer) write(URLData *models.URLData) error {
 	data, err := json.Marshal(&URLData)
 	if err != nil {
 		return err
 	}
+	if len(data) > MaxRecordSize {
+		return ErrRecordTooLarge
+	}
 	// добавляем перенос строки
 	if _, err := p.writer.Write(data); err != nil {
 		return err
 	}
-	if err := p.writer.WriteByte('\n'); err != nil {
+	return p.writer.WriteByte('\n')
+}
+
+// Append дописывает в файл строки, уже записанные другим Producer.
+func (p *Producer) Append(r io.Reader) error {
+	if err := p.writer.Flush(); err != nil {
 		return err
 	}
-	return p.writer.Flush()
+	_, err := io.Copy(p.file, r)
+	return err
+}
+
+func (p *Producer) Sync() error {
+	if err := p.writer.Flush(); err != nil {
+		return err
+	}
+	return p.file.Sync()
 }
 
 func (p *Producer) Close() error {
@@ -57,91 +150,29 @@ func NewConsumer(filename string) (*Consumer, error) {
 		return nil, err
 	}
 
+	scanner := bufio.NewScanner(file)
+	// +1 на перенос строки
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxRecordSize+1)
 	return &Consumer{
 		file:    file,
-		scanner: bufio.NewScanner(file),
+		scanner: scanner,
 	}, nil
 }
 
-func (c *Consumer) GetURL(shortURL string) (string, error) {
-	for c.scanner.Scan() {
-		var data models.URLData
-		if err := json.Unmarshal(c.scanner.Bytes(), &data); err != nil {
-			return "", err
-		}
-
-		if data.ShortURL == shortURL {
-			return data.OriginalURL, nil
-		}
-	}
-
-	if err := c.scanner.Err(); err != nil {
-		return "", err
-	}
-
-	return "", fmt.Errorf("ShortURL not found")
-}
-
-func (c *Consumer) Close() error {
-	return c.file.Close()
-}
-
-func (c *Consumer) GetURLsByUser(userID string) ([]*models.URLData, error) {
-	var URLData []*models.URLData
-	for c.scanner.Scan() {
-		var data models.URLData
-		if err := json.Unmarshal(c.scanner.Bytes(), &data); err != nil {
+func (c *Consumer) ReadEvent() (*models.URLData, error) {
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
 			return nil, err
 		}
-
-		if data.UserID == userID {
-			URLData = append(URLData, &data)
-		}
+		return nil, io.EOF
 	}
-
-	if err := c.scanner.Err(); err != nil {
+	var data models.URLData
+	if err := json.Unmarshal(c.scanner.Bytes(), &data); err != nil {
 		return nil, err
 	}
-
-	return URLData, nil
+	return &data, nil
 }
 
-func InsertDataIntoFile(cfg config.Config, URLData *models.URLData) error {
-	Producer, err := NewProducer(cfg.FileStoragePath)
-	if err != nil {
-		logger.Sugar.Error(err)
-	}
-	defer Producer.Close()
-	toFileSaveData := &models.URLData{
-		CorrelationID: URLData.CorrelationID,
-		OriginalURL:   URLData.OriginalURL,
-		ShortURL:      URLData.ShortURL,
-		UserID:        URLData.UserID,
-	}
-	if err := Producer.WriteEvent(toFileSaveData); err != nil {
-		logger.Sugar.Error(err)
-	}
-	logger.Sugar.Infof("URL inserted into file: %s:%s", URLData.OriginalURL, URLData.ShortURL)
-	return nil
-}
-
-func InsertBatchIntoFile(cfg config.Config, URLData []*models.URLData) error {
-	Producer, err := NewProducer(cfg.FileStoragePath)
-	if err != nil {
-		logger.Sugar.Error(err)
-	}
-	defer Producer.Close()
-	for _, data := range URLData {
-		toFileSaveData := &models.URLData{
-			CorrelationID: data.CorrelationID,
-			OriginalURL:   data.OriginalURL,
-			ShortURL:      data.ShortURL,
-			UserID:        data.UserID,
-		}
-		if err := Producer.WriteEvent(toFileSaveData); err != nil {
-			logger.Sugar.Errorf("Failed to write data to file: %v", err)
-		}
-	}
-	logger.Sugar.Infoln("Data saved to file")
-	return nil
+func (c *Consumer) Close() error {
+	return c.file.Close()
 }
@@ -3,7 +3,10 @@ package files
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
+	"io"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -44,73 +47,32 @@ func TestProducer_WriteEvent(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func TestConsumer_GetURL(t *testing.T) {
+func TestConsumer_ReadEventLongRecord(t *testing.T) {
 	cfg := config.Config{FileStoragePath: "test_data.log"}
+	defer os.Remove(cfg.FileStoragePath)
 	producer, err := NewProducer(cfg.FileStoragePath)
 	assert.NoError(t, err)
 	defer producer.Close()
 
 	urlData := &models.URLData{
-		OriginalURL:   "http://example.com",
-		ShortURL:      "exmpl",
-		CorrelationID: "12345",
-		UserID:        "user1",
+		OriginalURL: "http://example.com/" + strings.Repeat("a", 70*1024),
+		ShortURL:    "exmpl",
+		UserID:      "user1",
 	}
-
-	err = producer.WriteEvent(urlData)
-	assert.NoError(t, err)
+	assert.NoError(t, producer.WriteEvent(urlData))
+	tooLarge := &models.URLData{OriginalURL: "http://example.com/" + strings.Repeat("a", MaxRecordSize), ShortURL: "big"}
+	assert.ErrorIs(t, producer.WriteEvent(tooLarge), ErrRecordTooLarge)
 	producer.Close()
 
 	consumer, err := NewConsumer(cfg.FileStoragePath)
 	assert.NoError(t, err)
 	defer consumer.Close()
 
-	originalURL, err := consumer.GetURL("exmpl")
-	assert.NoError(t, err)
-	assert.Equal(t, "http://example.com", originalURL)
-
-	// Удаляем тестовый файл
-	err = os.Remove(cfg.FileStoragePath)
-	assert.NoError(t, err)
-}
-
-func TestConsumer_GetURLsByUser(t *testing.T) {
-	cfg := config.Config{FileStoragePath: "test_data.log"}
-	producer, err := NewProducer(cfg.FileStoragePath)
-	assert.NoError(t, err)
-	defer producer.Close()
-
-	urlData1 := &models.URLData{
-		OriginalURL:   "http://example1.com",
-		ShortURL:      "exmpl1",
-		CorrelationID: "12345",
-		UserID:        "user1",
-	}
-	urlData2 := &models.URLData{
-		OriginalURL:   "http://example2.com",
-		ShortURL:      "exmpl2",
-		CorrelationID: "12346",
-		UserID:        "user1",
-	}
-
-	err = producer.WriteEvent(urlData1)
-	assert.NoError(t, err)
-	err = producer.WriteEvent(urlData2)
-	assert.NoError(t, err)
-	producer.Close()
-
-	consumer, err := NewConsumer(cfg.FileStoragePath)
-	assert.NoError(t, err)
-	defer consumer.Close()
-
-	urls, err := consumer.GetURLsByUser("user1")
-	assert.NoError(t, err)
-	assert.Len(t, urls, 2)
-	assert.Equal(t, urlData1, urls[0])
-	assert.Equal(t, urlData2, urls[1])
-
-	err = os.Remove(cfg.FileStoragePath)
+	data, err := consumer.ReadEvent()
 	assert.NoError(t, err)
+	assert.Equal(t, urlData, data)
+	_, err = consumer.ReadEvent()
+	assert.ErrorIs(t, err, io.EOF)
 }
 
 func BenchmarkWriteEvent(b *testing.B) {
@@ -142,19 +104,10 @@ func BenchmarkWriteEvent(b *testing.B) {
 			}
 		}
 	})
-	b.Run("get", func(b *testing.B) {
-		for i := 0; i < b.N; i++ {
-			_, err := consumer.GetURL("exmpl")
-			if err != nil && err.Error() != "ShortURL not found" {
-				b.Fatalf("Failed to get URL: %v", err)
-			}
-		}
-	})
-	b.Run("get by user", func(b *testing.B) {
+	b.Run("read", func(b *testing.B) {
 		for i := 0; i < b.N; i++ {
-			_, err := consumer.GetURLsByUser("user1")
-			if err != nil {
-				b.Fatalf("Failed to get URLs by user: %v", err)
+			if _, err := consumer.ReadEvent(); err != nil && !errors.Is(err, io.EOF) {
+				b.Fatalf("Failed to read event: %v", err)
 			}
 		}
 	})
@@ -0,0 +1,70 @@
+// Package geoip определяет страну, регион и город по IP из локальной базы
+// в формате MaxMind DB (GeoLite2-City, GeoIP2-City и совместимые), без
+// обращений к внешним сервисам.
+package geoip
+
+import (
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Location — место, найденное по IP. Country — код ISO 3166-1, Region
+// и City — английские названия. Неизвестные поля пустые.
+type Location struct {
+	Country string
+	Region  string
+	City    string
+}
+
+type cityRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+type DB struct {
+	reader *maxminddb.Reader
+}
+
+// Open открывает файл базы. Файл отображается в память и читается
+// без блокировок, так что DB можно использовать из нескольких горутин.
+func Open(path string) (*DB, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{reader: reader}, nil
+}
+
+// Lookup ищет IP в базе. Для nil DB, некорректного IP и адресов, которых
+// нет в базе, возвращает пустой Location.
+func (db *DB) Lookup(ip string) Location {
+	if db == nil {
+		return Location{}
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return Location{}
+	}
+	var record cityRecord
+	if err := db.reader.Lookup(addr, &record); err != nil {
+		// например, IPv6-адрес в базе только для IPv4
+		return Location{}
+	}
+	loc := Location{Country: record.Country.ISOCode, City: record.City.Names["en"]}
+	if len(record.Subdivisions) > 0 {
+		loc.Region = record.Subdivisions[0].Names["en"]
+	}
+	return loc
+}
+
+func (db *DB) Close() error {
+	return db.reader.Close()
+}
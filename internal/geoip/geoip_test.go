@@ -0,0 +1,40 @@
+package geoip
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookup(t *testing.T) {
+	db, err := Open("testdata/GeoIP2-City-Test.mmdb")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	tests := []struct {
+		ip   string
+		want Location
+	}{
+		{"81.2.69.142", Location{Country: "GB", Region: "England", City: "London"}},
+		{"5.255.255.5", Location{Country: "RU", Region: "Moscow", City: "Moscow"}},
+		{"192.0.2.1", Location{Country: "US"}},
+		{"81.2.70.1", Location{}},
+		{"2001:db8::1", Location{}},
+		{"not-an-ip", Location{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			assert.Equal(t, tt.want, db.Lookup(tt.ip))
+		})
+	}
+}
+
+func TestNilDB(t *testing.T) {
+	var db *DB
+	assert.Equal(t, Location{}, db.Lookup("81.2.69.142"))
+}
+
+func TestOpenMissingFile(t *testing.T) {
+	_, err := Open("testdata/missing.mmdb")
+	assert.Error(t, err)
+}
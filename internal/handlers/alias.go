@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/thalq/url-service/config"
+	"github.com/thalq/url-service/internal/models"
+)
+
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{3,64}$`)
+
+// reservedAliases совпадают с путями сервиса и не могут быть алиасами.
+var reservedAliases = map[string]bool{
+	"api":     true,
+	"ping":    true,
+	"debug":   true,
+	"admin":   true,
+	"static":  true,
+	"health":  true,
+	"metrics": true,
+}
+
+func ifValidAlias(alias string) bool {
+	if !aliasPattern.MatchString(alias) {
+		return false
+	}
+	return !reservedAliases[strings.ToLower(alias)]
+}
+
+// shortLink возвращает публичную ссылку: по alias, если он задан.
+func shortLink(cfg config.Config, URLData models.URLData) string {
+	if URLData.Alias != "" {
+		return cfg.BaseURL + "/" + URLData.Alias
+	}
+	return cfg.BaseURL + "/" + URLData.ShortURL
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import "testing"
+
+func TestIfValidAlias(t *testing.T) {
+	tests := []struct {
+		name  string
+		alias string
+		want  bool
+	}{
+		{name: "valid alias", alias: "spring-sale", want: true},
+		{name: "valid with underscore and digits", alias: "Sale_2024", want: true},
+		{name: "too short", alias: "ab", want: false},
+		{name: "slash", alias: "spring/sale", want: false},
+		{name: "space", alias: "spring sale", want: false},
+		{name: "reserved", alias: "api", want: false},
+		{name: "reserved in other case", alias: "Debug", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ifValidAlias(tt.alias); got != tt.want {
+				t.Errorf("ifValidAlias(%q) = %v, want %v", tt.alias, got, tt.want)
+			}
+		})
+	}
+}
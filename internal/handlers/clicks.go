@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+)
+
+var errInvalidMaxClicks = errors.New("invalid max_clicks")
+
+// queryMaxClicks читает лимит переходов из query-параметра max_clicks
+// текстового POST /. 0 — без ограничения.
+func queryMaxClicks(query url.Values) (int, error) {
+	v := query.Get("max_clicks")
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, errInvalidMaxClicks
+	}
+	return n, nil
+}
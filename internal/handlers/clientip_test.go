@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("127.0.0.1/32")}
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		realIP    string
+		want      string
+	}{
+		{name: "direct", remote: "203.0.113.7:5000", want: "203.0.113.7"},
+		{name: "untrusted proxy headers ignored", remote: "203.0.113.7:5000", forwarded: "198.51.100.1", realIP: "198.51.100.2", want: "203.0.113.7"},
+		{name: "trusted proxy", remote: "10.0.0.1:5000", forwarded: "198.51.100.1", want: "198.51.100.1"},
+		{name: "spoofed left hops skipped", remote: "10.0.0.1:5000", forwarded: "1.1.1.1, 198.51.100.1, 10.0.0.2", want: "198.51.100.1"},
+		{name: "all hops trusted", remote: "10.0.0.1:5000", forwarded: "10.0.0.3, 10.0.0.2", want: "10.0.0.3"},
+		{name: "garbage in forwarded", remote: "127.0.0.1:5000", forwarded: "unknown", realIP: "198.51.100.3", want: "198.51.100.3"},
+		{name: "real ip", remote: "127.0.0.1:5000", realIP: "198.51.100.3", want: "198.51.100.3"},
+		{name: "trusted proxy without headers", remote: "127.0.0.1:5000", want: "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			assert.Equal(t, tt.want, clientIP(r, trusted))
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var errInvalidExpiry = errors.New("invalid expiry")
+
+// parseExpiry возвращает момент истечения ссылки по ttl в секундах или по
+// абсолютному expiresAt. Задать можно что-то одно, и момент должен быть
+// в будущем. Без обоих параметров ссылка бессрочная и возвращается nil.
+func parseExpiry(ttl int64, expiresAt *time.Time, now time.Time) (*time.Time, error) {
+	switch {
+	case ttl == 0 && expiresAt == nil:
+		return nil, nil
+	case ttl != 0 && expiresAt != nil:
+		return nil, errInvalidExpiry
+	case ttl < 0 || ttl > math.MaxInt64/int64(time.Second):
+		return nil, errInvalidExpiry
+	case ttl > 0:
+		t := now.Add(time.Duration(ttl) * time.Second).UTC()
+		return &t, nil
+	}
+	if !expiresAt.After(now) {
+		return nil, errInvalidExpiry
+	}
+	t := expiresAt.UTC()
+	return &t, nil
+}
+
+// queryExpiry читает ttl и expires_at (RFC 3339) из query-параметров
+// текстового POST /.
+func queryExpiry(query url.Values, now time.Time) (*time.Time, error) {
+	var ttl int64
+	var expiresAt *time.Time
+	if v := query.Get("ttl"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, errInvalidExpiry
+		}
+		ttl = n
+	}
+	if v := query.Get("expires_at"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, errInvalidExpiry
+		}
+		expiresAt = &t
+	}
+	return parseExpiry(ttl, expiresAt, now)
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseExpiry(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	future := now.Add(time.Hour)
+	past := now.Add(-time.Hour)
+
+	tests := []struct {
+		name      string
+		ttl       int64
+		expiresAt *time.Time
+		want      *time.Time
+		wantErr   bool
+	}{
+		{name: "no expiry"},
+		{name: "ttl", ttl: 3600, want: &future},
+		{name: "expires at", expiresAt: &future, want: &future},
+		{name: "expires at in the past", expiresAt: &past, wantErr: true},
+		{name: "negative ttl", ttl: -1, wantErr: true},
+		{name: "both", ttl: 60, expiresAt: &future, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseExpiry(tt.ttl, tt.expiresAt, now)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, errInvalidExpiry)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestQueryExpiry(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	got, err := queryExpiry(url.Values{"ttl": {"60"}}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(time.Minute), *got)
+
+	got, err = queryExpiry(url.Values{"expires_at": {"2024-03-02T00:00:00+03:00"}}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 3, 1, 21, 0, 0, 0, time.UTC), *got)
+
+	_, err = queryExpiry(url.Values{"ttl": {"soon"}}, now)
+	assert.ErrorIs(t, err, errInvalidExpiry)
+}
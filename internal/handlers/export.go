@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thalq/url-service/internal/analytics"
+)
+
+var errInvalidExport = errors.New("invalid export request")
+
+var exportContentTypes = map[string]string{
+	analytics.FormatCSV:    "text/csv; charset=utf-8",
+	analytics.FormatNDJSON: "application/x-ndjson",
+}
+
+// exportFormat выбирает формат выгрузки: явный ?format=csv|ndjson важнее
+// заголовка Accept, по умолчанию — CSV.
+func exportFormat(r *http.Request) (string, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		if _, ok := exportContentTypes[format]; !ok {
+			return "", errInvalidExport
+		}
+		return format, nil
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "text/csv":
+			return analytics.FormatCSV, nil
+		case "application/x-ndjson", "application/ndjson", "application/jsonl":
+			return analytics.FormatNDJSON, nil
+		}
+	}
+	return analytics.FormatCSV, nil
+}
+
+// queryExportRange читает необязательные from и to выгрузки. Без них
+// выгружаются все переходы.
+func queryExportRange(r *http.Request) (from, to time.Time, err error) {
+	query := r.URL.Query()
+	if v := query.Get("from"); v != "" {
+		if from, err = parseStatsTime(v); err != nil {
+			return from, to, errInvalidExport
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if to, err = parseStatsTime(v); err != nil {
+			return from, to, errInvalidExport
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return from, to, errInvalidExport
+	}
+	return from, to, nil
+}
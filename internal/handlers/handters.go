@@ -3,26 +3,51 @@ package handlers
 import (
 	"bytes"
 	"context"
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/go-chi/chi"
 	"github.com/google/uuid"
 	"github.com/thalq/url-service/config"
-	"github.com/thalq/url-service/internal/ch"
+	"github.com/thalq/url-service/internal/analytics"
 	"github.com/thalq/url-service/internal/constants"
-	"github.com/thalq/url-service/internal/files"
+	"github.com/thalq/url-service/internal/deletion"
+	"github.com/thalq/url-service/internal/geoip"
 	logger "github.com/thalq/url-service/internal/middleware"
 	"github.com/thalq/url-service/internal/models"
-	"github.com/thalq/url-service/internal/operations"
 	"github.com/thalq/url-service/internal/shortener"
+	"github.com/thalq/url-service/internal/storage"
 )
 
-func PostBodyHandler(cfg config.Config, db *sql.DB) http.HandlerFunc {
+// storeURL сохраняет ссылку, подбирая код без коллизий. Если original_url
+// уже сокращён, возвращает сохранённую ранее ссылку и статус 409.
+func storeURL(ctx context.Context, store storage.Storage, URLData *models.URLData) (models.URLData, int, error) {
+	newLink, err := shortener.Default.Shorten(URLData.OriginalURL, 0, func(code string) error {
+		URLData.ShortURL = code
+		return store.SaveURL(ctx, URLData)
+	})
+	if err == nil {
+		logger.Sugar.Infof("Data saved to storage with short link: %s", newLink)
+		return *URLData, http.StatusCreated, nil
+	}
+	logger.Sugar.Error(fmt.Sprintf("Failed to store URL: %v", err))
+	if !errors.Is(err, storage.ErrConflict) {
+		return models.URLData{}, http.StatusInternalServerError, err
+	}
+	existing, err := store.GetByOriginalURL(ctx, URLData.OriginalURL)
+	if err != nil {
+		logger.Sugar.Errorf("Failed to get existing URL: %v", err)
+		return models.URLData{}, http.StatusInternalServerError, err
+	}
+	return existing, http.StatusConflict, nil
+}
+
+func PostBodyHandler(cfg config.Config, store storage.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 		defer cancel()
@@ -41,59 +66,89 @@ func PostBodyHandler(cfg config.Config, db *sql.DB) http.HandlerFunc {
 			http.Error(w, "Не удалось прочитать тело запроса", http.StatusBadRequest)
 			return
 		}
-		logger.Sugar.Infof("Got request: %s", buf.String())
 		if err := json.Unmarshal(buf.Bytes(), &req); err != nil {
 			http.Error(w, "Не удалось распарсить JSON", http.StatusBadRequest)
 			return
 		}
-		logger.Sugar.Infof("Parsed request: %v", req)
+		// тело целиком не логируем: в нём может быть пароль ссылки
+		logger.Sugar.Infof("Parsed request: %s", req.URL)
 		url := req.URL
 		ifValidLink := ifValidURL(url)
 		if !ifValidLink {
 			http.Error(w, "Невалидный URL", http.StatusBadRequest)
 			return
 		}
-		newLink := shortener.GenerateShortString(url)
-		logger.Sugar.Infof("Generated short link: %s", newLink)
-
+		if req.Alias != "" && !ifValidAlias(req.Alias) {
+			http.Error(w, "Невалидный alias", http.StatusBadRequest)
+			return
+		}
+		expiresAt, err := parseExpiry(req.TTL, req.ExpiresAt, time.Now())
+		if err != nil {
+			http.Error(w, "Невалидный срок жизни ссылки", http.StatusBadRequest)
+			return
+		}
+		if req.MaxClicks < 0 {
+			http.Error(w, "Невалидный лимит переходов", http.StatusBadRequest)
+			return
+		}
+		passwordHash, err := hashPassword(req.Password)
+		if errors.Is(err, errInvalidPassword) {
+			http.Error(w, "Невалидный пароль", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Sugar.Errorf("Failed to hash password: %v", err)
+			http.Error(w, "Не удалось сохранить URL", http.StatusInternalServerError)
+			return
+		}
+		rules, err := validateRules(req.Rules, cfg.GeoIPPath != "")
+		if errors.Is(err, errNoGeoIP) {
+			http.Error(w, "Правила по стране недоступны без базы GeoIP", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Невалидные правила редиректа", http.StatusBadRequest)
+			return
+		}
+		if err := validateVariants(req.Variants); err != nil {
+			http.Error(w, "Невалидные варианты", http.StatusBadRequest)
+			return
+		}
 		var URLData = &models.URLData{
 			CorrelationID: uuid.New().String(),
 			OriginalURL:   url,
-			ShortURL:      newLink,
 			UserID:        userID,
+			Alias:         req.Alias,
+			ExpiresAt:     expiresAt,
+			MaxClicks:     req.MaxClicks,
+			PasswordHash:  passwordHash,
+			Rules:         rules,
+			Variants:      req.Variants,
 		}
 
-		resp.Result = cfg.BaseURL + "/" + newLink
-		response, err := json.Marshal(resp)
+		stored, status, err := storeURL(ctx, store, URLData)
+		if errors.Is(err, storage.ErrAliasTaken) {
+			http.Error(w, "Alias уже занят", http.StatusConflict)
+			return
+		}
 		if err != nil {
-			http.Error(w, "Не удалось записать ответ", http.StatusInternalServerError)
+			http.Error(w, "Не удалось сохранить URL", http.StatusInternalServerError)
 			return
 		}
 
-		if db != nil {
-			if err := operations.InsertURL(ctx, db, URLData); err != nil {
-				logger.Sugar.Error(fmt.Sprintf("Failed to store URL: %v", err))
-				w.Header().Set("content-type", "application/json")
-				w.WriteHeader(http.StatusConflict)
-				w.Write(response)
-				return
-			}
-			logger.Sugar.Infoln("Data saved to database")
-		} else {
-			err := files.InsertDataIntoFile(cfg, URLData)
-			if err != nil {
-				logger.Sugar.Error(err)
-			}
-			logger.Sugar.Infoln("Data saved to file")
+		resp.Result = shortLink(cfg, stored)
+		response, err := json.Marshal(resp)
+		if err != nil {
+			http.Error(w, "Не удалось записать ответ", http.StatusInternalServerError)
+			return
 		}
-
 		w.Header().Set("content-type", "application/json")
-		w.WriteHeader(http.StatusCreated)
+		w.WriteHeader(status)
 		w.Write(response)
 	}
 }
 
-func PostHandler(cfg config.Config, db *sql.DB) http.HandlerFunc {
+func PostHandler(cfg config.Config, store storage.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 		defer cancel()
@@ -120,40 +175,61 @@ func PostHandler(cfg config.Config, db *sql.DB) http.HandlerFunc {
 			http.Error(w, "Невалидный URL", http.StatusBadRequest)
 			return
 		}
-		newLink := shortener.GenerateShortString(bodyLink)
-
+		alias := r.URL.Query().Get("alias")
+		if alias != "" && !ifValidAlias(alias) {
+			http.Error(w, "Невалидный alias", http.StatusBadRequest)
+			return
+		}
+		expiresAt, err := queryExpiry(r.URL.Query(), time.Now())
+		if err != nil {
+			http.Error(w, "Невалидный срок жизни ссылки", http.StatusBadRequest)
+			return
+		}
+		maxClicks, err := queryMaxClicks(r.URL.Query())
+		if err != nil {
+			http.Error(w, "Невалидный лимит переходов", http.StatusBadRequest)
+			return
+		}
+		// пароль передаётся заголовком, а не в query, чтобы не попасть в логи
+		passwordHash, err := hashPassword(r.Header.Get("X-Link-Password"))
+		if errors.Is(err, errInvalidPassword) {
+			http.Error(w, "Невалидный пароль", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Sugar.Errorf("Failed to hash password: %v", err)
+			http.Error(w, "Не удалось сохранить URL", http.StatusInternalServerError)
+			return
+		}
 		var URLData = &models.URLData{
 			CorrelationID: uuid.New().String(),
 			OriginalURL:   bodyLink,
-			ShortURL:      newLink,
 			UserID:        userID,
+			Alias:         alias,
+			ExpiresAt:     expiresAt,
+			MaxClicks:     maxClicks,
+			PasswordHash:  passwordHash,
 		}
-		if db != nil {
-			if err := operations.InsertURL(ctx, db, URLData); err != nil {
-				logger.Sugar.Error(fmt.Sprintf("Failed to store URL: %v", err))
-				w.Header().Set("Content-Type", "text/plain")
-				w.WriteHeader(http.StatusConflict)
-				w.Write([]byte(cfg.BaseURL + "/" + URLData.ShortURL))
-				return
-			}
-			logger.Sugar.Infoln("Data saved to database")
-		} else {
-			err := files.InsertDataIntoFile(cfg, URLData)
-			if err != nil {
-				logger.Sugar.Error(err)
-			}
-			logger.Sugar.Infoln("Data saved to file")
+
+		stored, status, err := storeURL(ctx, store, URLData)
+		if errors.Is(err, storage.ErrAliasTaken) {
+			http.Error(w, "Alias уже занят", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Не удалось сохранить URL", http.StatusInternalServerError)
+			return
 		}
 
 		w.Header().Set("content-type", "text/plain")
-		w.WriteHeader(http.StatusCreated)
-		if _, err := w.Write([]byte(cfg.BaseURL + "/" + newLink)); err != nil {
+		w.WriteHeader(status)
+		if _, err := w.Write([]byte(shortLink(cfg, stored))); err != nil {
 			http.Error(w, "Не удалось записать ответ", http.StatusInternalServerError)
 		}
 	}
 }
 
-func PostBatchHandler(cfg config.Config, db *sql.DB) http.HandlerFunc {
+func PostBatchHandler(cfg config.Config, store storage.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx, cancel := context.WithTimeout(r.Context(), 100*time.Second)
 		defer cancel()
@@ -165,8 +241,6 @@ func PostBatchHandler(cfg config.Config, db *sql.DB) http.HandlerFunc {
 		}
 
 		var batchReq []models.BatchURLRequest
-		var batchResp []models.BatchURLResponse
-		var URLDatas []*models.URLData
 		var buf bytes.Buffer
 
 		_, err := buf.ReadFrom(r.Body)
@@ -174,34 +248,144 @@ func PostBatchHandler(cfg config.Config, db *sql.DB) http.HandlerFunc {
 			http.Error(w, "Не удалось прочитать тело запроса", http.StatusBadRequest)
 			return
 		}
-		logger.Sugar.Infof("Got request: %s", buf.String())
 		if err := json.Unmarshal(buf.Bytes(), &batchReq); err != nil {
 			http.Error(w, "Не удалось распарсить JSON", http.StatusBadRequest)
 			return
 		}
-		logger.Sugar.Infof("Parsed request: %v", batchReq)
-		for _, urlReq := range batchReq {
-			if valid := ifValidURL(urlReq.OriginalURL); !valid {
-				http.Error(w, "Невалидный URL", http.StatusBadRequest)
-				return
-			}
-			newLink := shortener.GenerateShortString(urlReq.OriginalURL)
-			logger.Sugar.Infof("Generated short link: %s", newLink)
+		if len(batchReq) == 0 {
+			http.Error(w, "Пустой список URL", http.StatusBadRequest)
+			return
+		}
+		logger.Sugar.Infof("Parsed request: %d items", len(batchReq))
 
+		now := time.Now()
+		batchResp := make([]models.BatchURLResponse, len(batchReq))
+		var URLDatas []*models.URLData
+		// respIdx[i] — позиция URLDatas[i] в ответе
+		var respIdx []int
+		for i, urlReq := range batchReq {
 			if urlReq.CorrelationID == "" {
 				urlReq.CorrelationID = uuid.New().String()
 			}
-
+			batchResp[i].CorrelationID = urlReq.CorrelationID
+			if valid := ifValidURL(urlReq.OriginalURL); !valid {
+				batchResp[i].Status = models.BatchStatusInvalid
+				batchResp[i].Error = "invalid url"
+				continue
+			}
+			if urlReq.Alias != "" && !ifValidAlias(urlReq.Alias) {
+				batchResp[i].Status = models.BatchStatusInvalid
+				batchResp[i].Error = "invalid alias"
+				continue
+			}
+			expiresAt, err := parseExpiry(urlReq.TTL, urlReq.ExpiresAt, now)
+			if err != nil {
+				batchResp[i].Status = models.BatchStatusInvalid
+				batchResp[i].Error = "invalid expiry"
+				continue
+			}
+			if urlReq.MaxClicks < 0 {
+				batchResp[i].Status = models.BatchStatusInvalid
+				batchResp[i].Error = "invalid max_clicks"
+				continue
+			}
+			passwordHash, err := hashPassword(urlReq.Password)
+			if err != nil {
+				batchResp[i].Status = models.BatchStatusInvalid
+				batchResp[i].Error = "invalid password"
+				continue
+			}
+			rules, err := validateRules(urlReq.Rules, cfg.GeoIPPath != "")
+			if errors.Is(err, errNoGeoIP) {
+				batchResp[i].Status = models.BatchStatusInvalid
+				batchResp[i].Error = "country rules require GeoIP"
+				continue
+			}
+			if err != nil {
+				batchResp[i].Status = models.BatchStatusInvalid
+				batchResp[i].Error = "invalid rules"
+				continue
+			}
+			if err := validateVariants(urlReq.Variants); err != nil {
+				batchResp[i].Status = models.BatchStatusInvalid
+				batchResp[i].Error = "invalid variants"
+				continue
+			}
 			URLDatas = append(URLDatas, &models.URLData{
 				CorrelationID: urlReq.CorrelationID,
 				OriginalURL:   urlReq.OriginalURL,
-				ShortURL:      newLink,
+				ShortURL:      shortener.Default.Code(urlReq.OriginalURL, 0),
 				UserID:        userID,
+				Alias:         urlReq.Alias,
+				ExpiresAt:     expiresAt,
+				MaxClicks:     urlReq.MaxClicks,
+				PasswordHash:  passwordHash,
+				Rules:         rules,
+				Variants:      urlReq.Variants,
 			})
-			batchResp = append(batchResp, models.BatchURLResponse{
-				CorrelationID: urlReq.CorrelationID,
-				ShortURL:      cfg.BaseURL + "/" + newLink,
-			})
+			respIdx = append(respIdx, i)
+		}
+
+		var created, existing, failed int
+		if len(URLDatas) > 0 {
+			itemErrs, err := store.SaveBatch(ctx, URLDatas)
+			if err != nil {
+				logger.Sugar.Errorf("Failed to store URLs: %v", err)
+				http.Error(w, "Не удалось сохранить URL", http.StatusInternalServerError)
+				return
+			}
+			for k, data := range URLDatas {
+				item := &batchResp[respIdx[k]]
+				if errors.Is(itemErrs[k], storage.ErrShortURLTaken) {
+					// коллизия: подбираем следующий код для этой ссылки по одной
+					_, itemErrs[k] = shortener.Default.Shorten(data.OriginalURL, 1, func(code string) error {
+						data.ShortURL = code
+						return store.SaveURL(ctx, data)
+					})
+				}
+				switch {
+				case itemErrs[k] == nil:
+					item.ShortURL = shortLink(cfg, *data)
+					item.Status = models.BatchStatusCreated
+					created++
+				case errors.Is(itemErrs[k], storage.ErrConflict):
+					stored, err := store.GetByOriginalURL(ctx, data.OriginalURL)
+					if err != nil {
+						logger.Sugar.Errorf("Failed to get existing URL: %v", err)
+						http.Error(w, "Не удалось сохранить URL", http.StatusInternalServerError)
+						return
+					}
+					item.ShortURL = shortLink(cfg, stored)
+					item.Status = models.BatchStatusExisting
+					existing++
+				case errors.Is(itemErrs[k], storage.ErrAliasTaken):
+					item.Status = models.BatchStatusInvalid
+					item.Error = "alias is taken"
+				case errors.Is(itemErrs[k], shortener.ErrTooManyCollisions):
+					// свободный код кончился у сервера, а не ошибка в запросе
+					logger.Sugar.Errorf("Failed to store URL %s: %v", data.OriginalURL, itemErrs[k])
+					item.Status = models.BatchStatusError
+					item.Error = "no free short url"
+					failed++
+				default:
+					logger.Sugar.Errorf("Failed to store URL %s: %v", data.OriginalURL, itemErrs[k])
+					item.Status = models.BatchStatusError
+					item.Error = "failed to store url"
+					failed++
+				}
+			}
+		}
+		logger.Sugar.Infof("Batch: %d created, %d existing, %d failed, %d invalid",
+			created, existing, failed, len(batchReq)-created-existing-failed)
+
+		status := http.StatusCreated
+		switch {
+		case failed > 0:
+			status = http.StatusInternalServerError
+		case created == 0 && existing > 0:
+			status = http.StatusConflict
+		case created == 0:
+			status = http.StatusBadRequest
 		}
 
 		response, err := json.Marshal(batchResp)
@@ -209,159 +393,180 @@ func PostBatchHandler(cfg config.Config, db *sql.DB) http.HandlerFunc {
 			http.Error(w, "Не удалось записать ответ", http.StatusInternalServerError)
 			return
 		}
-		if db != nil {
-			logger.Sugar.Infoln("Database connection established")
-			if err := operations.ExecInsertBatchURLs(ctx, db, URLDatas); err != nil {
-				logger.Sugar.Error(fmt.Sprintf("Failed to store URL: %v", err))
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusConflict)
-				w.Write(response)
-				return
-			}
-		} else {
-			if err := files.InsertBatchIntoFile(cfg, URLDatas); err != nil {
-				logger.Sugar.Errorf("Failed to store URL: %v", err)
-			}
-		}
 		w.Header().Set("content-type", "application/json")
-		w.WriteHeader(http.StatusCreated)
+		w.WriteHeader(status)
 		w.Write(response)
 	}
 }
 
-func GetHandler(cfg config.Config, db *sql.DB) http.HandlerFunc {
+// GetHandler перенаправляет на исходный URL, адрес первого подошедшего
+// правила или вариант A/B-теста и записывает переход в clicks. geo нужен правилам по стране,
+// без него страна посетителя неизвестна.
+func GetHandler(cfg config.Config, store storage.Storage, clicks *analytics.Recorder, geo *geoip.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 		defer cancel()
 
 		shortURL := strings.TrimPrefix(r.URL.Path, "/")
 		logger.Sugar.Infoln("GET: Requested key:", shortURL)
-		if db != nil {
-			URLData, err := operations.GetURLData(ctx, db, shortURL)
-			if err != nil {
-				http.Error(w, "ShortURL not found in database", http.StatusNotFound)
+		URLData, ok := liveURL(ctx, w, store, shortURL)
+		if !ok {
+			return
+		}
+		if URLData.PasswordHash != "" {
+			writePasswordForm(w, http.StatusOK, "")
+			return
+		}
+		if !countVisit(ctx, w, store, URLData) {
+			return
+		}
+		logger.Sugar.Infoln("GET: Original URL from storage:", URLData.OriginalURL)
+		ip := clientIP(r, cfg.TrustedProxies)
+		target, variant := destination(w, r, URLData, ip, geo)
+		w.Header().Set("Location", target)
+		w.WriteHeader(http.StatusTemporaryRedirect)
+		clicks.Record(r, URLData.ShortURL, variant, ip)
+		logger.Sugar.Infoln("Temporary Redirect sent for URL:", URLData.OriginalURL)
+	}
+}
+
+// PostPasswordHandler принимает пароль из формы защищённой ссылки и после
+// проверки отправляет на исходный URL. Неверные пароли ограничены: после 5 ошибок
+// клиента или 50 ошибок по ссылке в целом попытки блокируются на 15 минут.
+func PostPasswordHandler(cfg config.Config, store storage.Storage, clicks *analytics.Recorder, geo *geoip.DB) http.HandlerFunc {
+	limiter := newPasswordLimiter(5, 50, 15*time.Minute)
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		shortURL := strings.TrimPrefix(r.URL.Path, "/")
+		URLData, ok := liveURL(ctx, w, store, shortURL)
+		if !ok {
+			return
+		}
+		if URLData.PasswordHash != "" {
+			ip := clientIP(r, cfg.TrustedProxies)
+			if !limiter.allow(URLData.ShortURL, ip, time.Now()) {
+				logger.Sugar.Infof("Too many password attempts for %s", shortURL)
+				writePasswordForm(w, http.StatusTooManyRequests, "Слишком много попыток, попробуйте позже")
 				return
 			}
-			if URLData.DeletedFlag {
-				logger.Sugar.Infoln("ShortURL is deleted")
-				w.WriteHeader(http.StatusGone)
-			} else {
-				logger.Sugar.Infoln("GET: Original URL from database:", URLData.OriginalURL)
-				w.Header().Set("Location", URLData.OriginalURL)
-				w.WriteHeader(http.StatusTemporaryRedirect)
-				logger.Sugar.Infoln("Temporary Redirect sent for URL:", URLData.OriginalURL)
-			}
-		} else {
-			Consumer, err := files.NewConsumer(cfg.FileStoragePath)
-			if err != nil {
-				logger.Sugar.Fatal(err)
-			}
-			defer Consumer.Close()
-			OriginalURL, err := Consumer.GetURL(shortURL)
-			if err != nil {
-				logger.Sugar.Error("ShortURL not found in file")
-				http.Error(w, "ShortURL not found", http.StatusNotFound)
+			if !checkPassword(URLData.PasswordHash, r.PostFormValue("password")) {
+				limiter.fail(URLData.ShortURL, ip, time.Now())
+				writePasswordForm(w, http.StatusUnauthorized, "Неверный пароль")
 				return
 			}
-			logger.Sugar.Infoln("GET: Original URL from file:", OriginalURL)
-			w.Header().Set("Location", OriginalURL)
-			w.WriteHeader(http.StatusTemporaryRedirect)
-			logger.Sugar.Infoln("Temporary Redirect sent for URL:", OriginalURL)
+			limiter.success(URLData.ShortURL, ip)
 		}
+		if !countVisit(ctx, w, store, URLData) {
+			return
+		}
+		// 303, а не 307: на 307 браузер повторил бы POST с паролем на исходный URL
+		ip := clientIP(r, cfg.TrustedProxies)
+		target, variant := destination(w, r, URLData, ip, geo)
+		w.Header().Set("Location", target)
+		w.WriteHeader(http.StatusSeeOther)
+		clicks.Record(r, URLData.ShortURL, variant, ip)
+	}
+}
+
+// liveURL ищет ссылку для редиректа. Если её нет, она удалена или истекла,
+// пишет 404 или 410 и возвращает false.
+func liveURL(ctx context.Context, w http.ResponseWriter, store storage.Storage, shortURL string) (models.URLData, bool) {
+	URLData, err := store.GetURL(ctx, shortURL)
+	if err != nil {
+		logger.Sugar.Errorf("Failed to get URL: %v", err)
+		http.Error(w, "ShortURL not found", http.StatusNotFound)
+		return URLData, false
+	}
+	if URLData.DeletedFlag {
+		logger.Sugar.Infoln("ShortURL is deleted")
+		w.WriteHeader(http.StatusGone)
+		return URLData, false
 	}
+	if URLData.Expired(time.Now()) {
+		logger.Sugar.Infoln("ShortURL is expired")
+		w.WriteHeader(http.StatusGone)
+		return URLData, false
+	}
+	return URLData, true
 }
 
-func GetByUserHandler(cfg config.Config, db *sql.DB) http.HandlerFunc {
+// countVisit засчитывает переход по ссылке с лимитом переходов. Если лимит
+// исчерпан или счётчик не удалось обновить, пишет ответ и возвращает false.
+func countVisit(ctx context.Context, w http.ResponseWriter, store storage.Storage, URLData models.URLData) bool {
+	if URLData.MaxClicks == 0 {
+		return true
+	}
+	err := store.Visit(ctx, URLData.ShortURL)
+	if errors.Is(err, storage.ErrClicksExhausted) {
+		logger.Sugar.Infoln("ShortURL click limit reached")
+		w.WriteHeader(http.StatusGone)
+		return false
+	}
+	if err != nil {
+		logger.Sugar.Errorf("Failed to count visit: %v", err)
+		http.Error(w, "Не удалось учесть переход", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+func GetByUserHandler(cfg config.Config, store storage.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx, cancel := context.WithTimeout(r.Context(), 100*time.Second)
 		defer cancel()
 
 		userID, ok := ctx.Value(constants.UserIDKey).(string)
-
-		fmt.Println("UserID: ", userID)
 		if !ok {
 			http.Error(w, "User ID not found", http.StatusUnauthorized)
 			return
 		}
 
-		if db != nil {
-			URLData, err := operations.GetUserURLData(ctx, db, userID)
-			if err != nil {
-				http.Error(w, "ShortURL not found in database", http.StatusNotFound)
-				return
-			}
-			if len(URLData) == 0 {
-				logger.Sugar.Infof("No URLs found for user %s", userID)
-				w.WriteHeader(http.StatusUnauthorized) // а надо бы 204 No Content
-				return
-			}
-			var resp []models.ShortURLData
-			for _, data := range URLData {
-				resp = append(resp, models.ShortURLData{
-					OriginalURL: data.OriginalURL,
-					ShortURL:    cfg.BaseURL + "/" + data.ShortURL,
-				})
-			}
-			logger.Sugar.Infof("Get %d URLData from database", len(URLData))
-			response, err := json.Marshal(resp)
-			if err != nil {
-				http.Error(w, "Не удалось записать ответ", http.StatusInternalServerError)
-				return
-			}
-			w.Header().Set("content-type", "application/json")
-			w.Write(response)
+		URLData, err := store.GetUserURLs(ctx, userID)
+		if err != nil {
+			logger.Sugar.Errorf("Failed to get user URLs: %v", err)
+			http.Error(w, "Не удалось получить список URL", http.StatusInternalServerError)
 			return
-		} else {
-			Consumer, err := files.NewConsumer(cfg.FileStoragePath)
-			if err != nil {
-				logger.Sugar.Fatal(err)
-			}
-			defer Consumer.Close()
-			URLData, err := Consumer.GetURLsByUser(userID)
-			if err != nil {
-				logger.Sugar.Error("No URLs found for user")
-				return
-			}
-			logger.Sugar.Infof("Get %d URLData from database", len(URLData))
-
-			var resp []models.ShortURLData
-			for _, data := range URLData {
-				resp = append(resp, models.ShortURLData{
-					OriginalURL: data.OriginalURL,
-					ShortURL:    cfg.BaseURL + "/" + data.ShortURL,
-				})
-			}
-			w.WriteHeader(http.StatusTemporaryRedirect)
-			response, err := json.Marshal(resp)
-			if err != nil {
-				http.Error(w, "Не удалось записать ответ", http.StatusInternalServerError)
-				return
-			}
-			w.Header().Set("content-type", "application/json")
-			w.Write(response)
+		}
+		if len(URLData) == 0 {
+			logger.Sugar.Infof("No URLs found for user %s", userID)
+			w.WriteHeader(http.StatusUnauthorized) // а надо бы 204 No Content
 			return
 		}
+		var resp []models.ShortURLData
+		for _, data := range URLData {
+			resp = append(resp, shortURLData(cfg, data))
+		}
+		logger.Sugar.Infof("Get %d URLData from storage", len(URLData))
+		response, err := json.Marshal(resp)
+		if err != nil {
+			http.Error(w, "Не удалось записать ответ", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		w.Write(response)
 	}
 }
 
-func GetPingHandler(cfg config.Config, db *sql.DB) http.HandlerFunc {
+func GetPingHandler(cfg config.Config, store storage.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if db == nil {
-			logger.Sugar.Error("Database connection error")
-			http.Error(w, "Database connection error", http.StatusInternalServerError)
+		if err := store.Ping(r.Context()); err != nil {
+			logger.Sugar.Errorf("Storage connection error: %v", err)
+			http.Error(w, "Storage connection error", http.StatusInternalServerError)
 			return
 		}
-		logger.Sugar.Infoln("Database connection established")
+		logger.Sugar.Infoln("Storage connection established")
 		w.WriteHeader(http.StatusOK)
 	}
 }
 
-func DeleteByList(cfg config.Config, db *sql.DB) http.HandlerFunc {
+// DeleteByList ставит ссылки пользователя в очередь на удаление и отвечает
+// 202 с заданием, как только запрос записан в журнал очереди. Ход удаления
+// отдаёт GetDeletionHandler.
+func DeleteByList(cfg config.Config, queue *deletion.Queue) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
-		defer cancel()
-
-		userID, ok := ctx.Value(constants.UserIDKey).(string)
+		userID, ok := r.Context().Value(constants.UserIDKey).(string)
 		if !ok {
 			http.Error(w, "User ID not found", http.StatusUnauthorized)
 			return
@@ -381,19 +586,344 @@ func DeleteByList(cfg config.Config, db *sql.DB) http.HandlerFunc {
 			return
 		}
 		logger.Sugar.Infof("Parsed request: %v", req)
+
+		job, err := queue.Enqueue(userID, req.ShortURLs)
+		if err != nil {
+			logger.Sugar.Errorf("Failed to enqueue deletion: %v", err)
+			http.Error(w, "Не удалось поставить ссылки в очередь на удаление", http.StatusInternalServerError)
+			return
+		}
+		response, err := json.Marshal(job)
+		if err != nil {
+			http.Error(w, "Не удалось записать ответ", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		w.Header().Set("Location", "/api/user/deletions/"+job.ID)
 		w.WriteHeader(http.StatusAccepted)
+		w.Write(response)
+	}
+}
 
-		if db != nil {
-			var UrlsToDelete []models.ChDelete
-			for _, shortURL := range req.ShortURLs {
-				UrlsToDelete = append(UrlsToDelete, models.ChDelete{
-					UserID:   userID,
-					ShortURL: shortURL,
-				})
-			}
-			ch.DeleteURLData(ctx, db, UrlsToDelete...)
+// GetDeletionHandler отдаёт состояние задания на удаление. Задания других
+// пользователей не видны.
+func GetDeletionHandler(cfg config.Config, queue *deletion.Queue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value(constants.UserIDKey).(string)
+		if !ok {
+			http.Error(w, "User ID not found", http.StatusUnauthorized)
+			return
+		}
+		job, ok := queue.Job(userID, chi.URLParam(r, "id"))
+		if !ok {
+			http.Error(w, "Deletion job not found", http.StatusNotFound)
+			return
+		}
+		response, err := json.Marshal(job)
+		if err != nil {
+			http.Error(w, "Не удалось записать ответ", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		w.Write(response)
+	}
+}
+
+// RestoreByList восстанавливает удалённые ссылки пользователя, если с момента
+// удаления прошло не больше cfg.RestorePeriod. В ответе — коды восстановленных
+// ссылок, остальные коды из запроса пропускаются.
+func RestoreByList(cfg config.Config, store storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		userID, ok := ctx.Value(constants.UserIDKey).(string)
+		if !ok {
+			http.Error(w, "User ID not found", http.StatusUnauthorized)
+			return
+		}
+		if cfg.RestorePeriod <= 0 {
+			http.Error(w, "Восстановление ссылок отключено", http.StatusForbidden)
+			return
+		}
+
+		var shortURLs []string
+		if err := json.NewDecoder(r.Body).Decode(&shortURLs); err != nil {
+			http.Error(w, "Не удалось распарсить JSON", http.StatusBadRequest)
+			return
+		}
+		restored, err := store.RestoreURLs(ctx, userID, shortURLs, time.Now().Add(-cfg.RestorePeriod))
+		if err != nil {
+			logger.Sugar.Errorf("Failed to restore URLs: %v", err)
+			http.Error(w, "Не удалось восстановить URL", http.StatusInternalServerError)
+			return
+		}
+		logger.Sugar.Infof("Restored %d of %d URLs", len(restored), len(shortURLs))
+		if restored == nil {
+			restored = []string{}
+		}
+
+		response, err := json.Marshal(restored)
+		if err != nil {
+			http.Error(w, "Не удалось записать ответ", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		w.Write(response)
+	}
+}
+
+// shortURLData — ссылка в том виде, в каком её видит владелец.
+func shortURLData(cfg config.Config, data models.URLData) models.ShortURLData {
+	return models.ShortURLData{
+		OriginalURL: data.OriginalURL,
+		ShortURL:    shortLink(cfg, data),
+		ExpiresAt:   data.ExpiresAt,
+		MaxClicks:   data.MaxClicks,
+		Clicks:      data.Clicks,
+		Protected:   data.PasswordHash != "",
+		UpdatedAt:   data.UpdatedAt,
+		IsDeleted:   data.DeletedFlag,
+		DeletedAt:   data.DeletedAt,
+		Rules:       data.Rules,
+		Variants:    data.Variants,
+	}
+}
+
+// ownURL ищет ссылку пользователя по коду или alias. Чужая ссылка
+// отдаётся как несуществующая, чтобы не раскрывать занятые коды.
+func ownURL(ctx context.Context, w http.ResponseWriter, store storage.Storage, userID, shortURL string) (models.URLData, bool) {
+	URLData, err := store.GetURL(ctx, shortURL)
+	if errors.Is(err, storage.ErrNotFound) || (err == nil && URLData.UserID != userID) {
+		http.Error(w, "ShortURL not found", http.StatusNotFound)
+		return URLData, false
+	}
+	if err != nil {
+		logger.Sugar.Errorf("Failed to get URL: %v", err)
+		http.Error(w, "Не удалось получить URL", http.StatusInternalServerError)
+		return URLData, false
+	}
+	return URLData, true
+}
+
+// PatchURLHandler перенаправляет ссылку владельца на новый исходный URL.
+func PatchURLHandler(cfg config.Config, store storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		userID, ok := ctx.Value(constants.UserIDKey).(string)
+		if !ok {
+			http.Error(w, "User ID not found", http.StatusUnauthorized)
+			return
+		}
+
+		var req models.UpdateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Не удалось распарсить JSON", http.StatusBadRequest)
+			return
+		}
+		if !ifValidURL(req.URL) {
+			http.Error(w, "Невалидный URL", http.StatusBadRequest)
+			return
+		}
+		URLData, ok := ownURL(ctx, w, store, userID, chi.URLParam(r, "short"))
+		if !ok {
+			return
+		}
+		if URLData.DeletedFlag {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+
+		updated, err := store.UpdateURL(ctx, userID, URLData.ShortURL, req.URL)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			http.Error(w, "ShortURL not found", http.StatusNotFound)
+			return
+		case errors.Is(err, storage.ErrConflict):
+			http.Error(w, "URL уже сокращён", http.StatusConflict)
+			return
+		case err != nil:
+			logger.Sugar.Errorf("Failed to update URL: %v", err)
+			http.Error(w, "Не удалось изменить URL", http.StatusInternalServerError)
+			return
+		}
+		logger.Sugar.Infof("URL %s now points to %s", updated.ShortURL, updated.OriginalURL)
 
-			logger.Sugar.Infoln("Data deleted from database")
+		response, err := json.Marshal(shortURLData(cfg, updated))
+		if err != nil {
+			http.Error(w, "Не удалось записать ответ", http.StatusInternalServerError)
+			return
 		}
+		w.Header().Set("content-type", "application/json")
+		w.Write(response)
 	}
 }
+
+// GetURLHistoryHandler отдаёт владельцу прежние исходные URL ссылки.
+func GetURLHistoryHandler(cfg config.Config, store storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		userID, ok := ctx.Value(constants.UserIDKey).(string)
+		if !ok {
+			http.Error(w, "User ID not found", http.StatusUnauthorized)
+			return
+		}
+		URLData, ok := ownURL(ctx, w, store, userID, chi.URLParam(r, "short"))
+		if !ok {
+			return
+		}
+		history, err := store.GetURLHistory(ctx, URLData.ShortURL)
+		if err != nil {
+			logger.Sugar.Errorf("Failed to get URL history: %v", err)
+			http.Error(w, "Не удалось получить историю URL", http.StatusInternalServerError)
+			return
+		}
+		if history == nil {
+			history = []models.URLHistory{}
+		}
+		response, err := json.Marshal(history)
+		if err != nil {
+			http.Error(w, "Не удалось записать ответ", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		w.Write(response)
+	}
+}
+
+// GetURLStatsHandler отдаёт владельцу статистику переходов по ссылке.
+func GetURLStatsHandler(cfg config.Config, store storage.Storage, clicks analytics.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		defer cancel()
+
+		userID, ok := ctx.Value(constants.UserIDKey).(string)
+		if !ok {
+			http.Error(w, "User ID not found", http.StatusUnauthorized)
+			return
+		}
+		from, to, interval, err := queryStatsRange(r.URL.Query(), time.Now())
+		if err != nil {
+			http.Error(w, "Некорректный период статистики", http.StatusBadRequest)
+			return
+		}
+		URLData, ok := ownURL(ctx, w, store, userID, chi.URLParam(r, "short"))
+		if !ok {
+			return
+		}
+		stats, err := analytics.Stats(ctx, clicks, URLData.ShortURL, from, to, interval)
+		if errors.Is(err, analytics.ErrInvalidRange) {
+			http.Error(w, "Некорректный период статистики", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Sugar.Errorf("Failed to get URL stats: %v", err)
+			http.Error(w, "Не удалось получить статистику", http.StatusInternalServerError)
+			return
+		}
+		response, err := json.Marshal(stats)
+		if err != nil {
+			http.Error(w, "Не удалось записать ответ", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		w.Write(response)
+	}
+}
+
+// ExportURLClicksHandler выгружает владельцу переходы по ссылке в CSV
+// или NDJSON.
+func ExportURLClicksHandler(cfg config.Config, store storage.Storage, clicks analytics.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value(constants.UserIDKey).(string)
+		if !ok {
+			http.Error(w, "User ID not found", http.StatusUnauthorized)
+			return
+		}
+		URLData, ok := ownURL(r.Context(), w, store, userID, chi.URLParam(r, "short"))
+		if !ok {
+			return
+		}
+		exportClicks(w, r, clicks, []string{URLData.ShortURL}, "clicks-"+URLData.ShortURL)
+	}
+}
+
+// ExportUserClicksHandler выгружает переходы по всем ссылкам пользователя.
+func ExportUserClicksHandler(cfg config.Config, store storage.Storage, clicks analytics.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value(constants.UserIDKey).(string)
+		if !ok {
+			http.Error(w, "User ID not found", http.StatusUnauthorized)
+			return
+		}
+		URLData, err := store.GetUserURLs(r.Context(), userID)
+		if err != nil {
+			logger.Sugar.Errorf("Failed to get user URLs: %v", err)
+			http.Error(w, "Не удалось получить список URL", http.StatusInternalServerError)
+			return
+		}
+		shortURLs := make([]string, 0, len(URLData))
+		for _, data := range URLData {
+			shortURLs = append(shortURLs, data.ShortURL)
+		}
+		exportClicks(w, r, clicks, shortURLs, "clicks")
+	}
+}
+
+// exportClicks пишет выгрузку потоком, без общего таймаута: большие
+// выгрузки идут дольше обычных запросов. Ошибку посреди выгрузки клиенту
+// уже не сообщить, ответ просто обрывается.
+func exportClicks(w http.ResponseWriter, r *http.Request, clicks analytics.Store, shortURLs []string, name string) {
+	format, err := exportFormat(r)
+	if err != nil {
+		http.Error(w, "Неизвестный формат выгрузки", http.StatusBadRequest)
+		return
+	}
+	from, to, err := queryExportRange(r)
+	if err != nil {
+		http.Error(w, "Некорректный период выгрузки", http.StatusBadRequest)
+		return
+	}
+	out := &exportWriter{
+		ResponseWriter: w,
+		contentType:    exportContentTypes[format],
+		filename:       name + "." + format,
+	}
+	filter := analytics.Filter{ShortURLs: shortURLs, From: from, To: to}
+	if err := analytics.Export(r.Context(), clicks, filter, format, out); err != nil {
+		logger.Sugar.Errorf("Failed to export clicks: %v", err)
+		if !out.started {
+			http.Error(w, "Не удалось выгрузить переходы", http.StatusInternalServerError)
+		}
+		return
+	}
+	// пустая NDJSON-выгрузка ничего не пишет, но заголовки нужны и ей
+	out.start()
+}
+
+// exportWriter выставляет заголовки выгрузки только при первой записи,
+// чтобы ошибка в начале обхода превратилась в 500, а не в пустой файл.
+type exportWriter struct {
+	http.ResponseWriter
+	contentType string
+	filename    string
+	started     bool
+}
+
+func (w *exportWriter) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+	w.Header().Set("content-type", w.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, w.filename))
+}
+
+func (w *exportWriter) Write(p []byte) (int, error) {
+	w.start()
+	return w.ResponseWriter.Write(p)
+}
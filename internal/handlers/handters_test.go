@@ -1,22 +1,30 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
-	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/go-chi/chi"
 	"github.com/stretchr/testify/assert"
 	"github.com/thalq/url-service/config"
-	database "github.com/thalq/url-service/internal/dataBase"
-	"github.com/thalq/url-service/internal/files"
+	"github.com/thalq/url-service/internal/analytics"
+	"github.com/thalq/url-service/internal/deletion"
 	logger "github.com/thalq/url-service/internal/middleware"
 	"github.com/thalq/url-service/internal/models"
 	"github.com/thalq/url-service/internal/shortener"
+	"github.com/thalq/url-service/internal/storage"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
 )
 
 var testLogger *zap.Logger
@@ -37,8 +45,9 @@ func TestHandlers(t *testing.T) {
 	cfg := config.ParseConfig()
 	cfg.Address = "localhost:8080"
 	cfg.BaseURL = "http://localhost:8080"
+	cfg.FileStoragePath = ""
 	logger.Sugar = sugar
-	db := database.DBConnect(cfg)
+	store := storage.NewMemoryStorage()
 
 	r := chi.NewRouter()
 	r.Use(logger.WithLogging)
@@ -46,10 +55,10 @@ func TestHandlers(t *testing.T) {
 	r.Use(logger.CookieMiddleware)
 
 	r.Route("/", func(r chi.Router) {
-		r.Post("/", PostHandler(cfg, db))
-		r.Post("/api/shorten", PostBodyHandler(cfg, db))
-		r.Post("/api/shorten/batch", PostBatchHandler(cfg, db))
-		r.Get("/*", GetHandler(cfg, db))
+		r.Post("/", PostHandler(cfg, store))
+		r.Post("/api/shorten", PostBodyHandler(cfg, store))
+		r.Post("/api/shorten/batch", PostBatchHandler(cfg, store))
+		r.Get("/*", GetHandler(cfg, store, nil, nil))
 	})
 
 	t.Run("POST valid URL", func(t *testing.T) {
@@ -76,7 +85,7 @@ func TestHandlers(t *testing.T) {
 
 	t.Run("POST batch valid URLs", func(t *testing.T) {
 		reqBody := `[
-			{"original_url": "http://example.com"},
+			{"original_url": "http://example.net"},
 			{"original_url": "http://example.org"}
 		]`
 
@@ -102,16 +111,11 @@ func TestHandlers(t *testing.T) {
 
 	t.Run("GET valid URL", func(t *testing.T) {
 		shortURL := shortener.GenerateShortString("https://test.com")
-		Producer, err := files.NewProducer(cfg.FileStoragePath)
-		if err != nil {
-			logger.Sugar.Fatal(err)
-		}
-		defer Producer.Close()
 		var URLData = &models.URLData{
 			OriginalURL: "https://test.com",
 			ShortURL:    shortURL,
 		}
-		if err := Producer.WriteEvent(URLData); err != nil {
+		if err := store.SaveURL(context.Background(), URLData); err != nil {
 			logger.Sugar.Fatal(err)
 		}
 
@@ -139,16 +143,11 @@ func TestHandlers(t *testing.T) {
 
 	t.Run("GET valid URL with JSON body", func(t *testing.T) {
 		shortURL := shortener.GenerateShortString("https://test1.com")
-		Producer, err := files.NewProducer(cfg.FileStoragePath)
-		if err != nil {
-			logger.Sugar.Fatal(err)
-		}
-		defer Producer.Close()
 		var URLData = &models.URLData{
 			OriginalURL: "https://test1.com",
 			ShortURL:    shortURL,
 		}
-		if err := Producer.WriteEvent(URLData); err != nil {
+		if err := store.SaveURL(context.Background(), URLData); err != nil {
 			logger.Sugar.Fatal(err)
 		}
 
@@ -161,9 +160,710 @@ func TestHandlers(t *testing.T) {
 		assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
 		assert.Equal(t, "https://test1.com", rec.Header().Get("Location"))
 	})
+}
 
-	err := os.Remove("./url_data.log")
-	if err != nil && !os.IsNotExist(err) {
-		t.Fatalf("Failed to remove file: %v", err)
+func TestDeleteByListFileStorage(t *testing.T) {
+	logger.Sugar = sugar
+
+	cfg := config.Config{
+		BaseURL:         "http://localhost:8080",
+		FileStoragePath: filepath.Join(t.TempDir(), "test_data.log"),
+	}
+	store, err := storage.NewFileStorage(cfg.FileStoragePath)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	r := chi.NewRouter()
+	r.Use(logger.CookieMiddleware)
+	r.Post("/", PostHandler(cfg, store))
+	queue, err := deletion.New(store, deletion.Options{})
+	assert.NoError(t, err)
+	r.Delete("/api/user/urls", DeleteByList(cfg, queue))
+	r.Get("/api/user/deletions/{id}", GetDeletionHandler(cfg, queue))
+	r.Get("/*", GetHandler(cfg, store, nil, nil))
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://deleted.com"))
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	cookies := rec.Result().Cookies()
+	shortURL := strings.TrimPrefix(rec.Body.String(), cfg.BaseURL+"/")
+
+	req = httptest.NewRequest(http.MethodDelete, "/api/user/urls", strings.NewReader(`["`+shortURL+`"]`))
+	for _, c := range cookies {
+		req.AddCookie(c)
 	}
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	var job models.DeletionJob
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &job))
+	assert.Equal(t, 1, job.Pending)
+	assert.Equal(t, "/api/user/deletions/"+job.ID, rec.Header().Get("Location"))
+	assert.Equal(t, 1, queue.Pending())
+	assert.NoError(t, queue.Flush(context.Background()))
+
+	req = httptest.NewRequest(http.MethodGet, "/api/user/deletions/"+job.ID, nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"id":"`+job.ID+`","pending":0,"completed":1,"failed":0,"not_found":0,"urls":{"`+shortURL+`":"completed"}}`, rec.Body.String())
+
+	// без cookie владельца задание не видно
+	req = httptest.NewRequest(http.MethodGet, "/api/user/deletions/"+job.ID, nil)
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/"+shortURL, nil)
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusGone, rec.Code)
+}
+
+func TestPostConflictReturnsStoredShortURL(t *testing.T) {
+	logger.Sugar = sugar
+
+	cfg := config.Config{BaseURL: "http://localhost:8080"}
+	store := storage.NewMemoryStorage()
+	err := store.SaveURL(context.Background(), &models.URLData{
+		OriginalURL: "https://stored.com",
+		ShortURL:    "stored1",
+		UserID:      "user1",
+	})
+	assert.NoError(t, err)
+
+	r := chi.NewRouter()
+	r.Use(logger.CookieMiddleware)
+	r.Post("/", PostHandler(cfg, store))
+	r.Post("/api/shorten", PostBodyHandler(cfg, store))
+	r.Post("/api/shorten/batch", PostBatchHandler(cfg, store))
+
+	t.Run("text", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://stored.com")))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Equal(t, "http://localhost:8080/stored1", rec.Body.String())
+	})
+
+	t.Run("json", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://stored.com"}`)))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.JSONEq(t, `{"result":"http://localhost:8080/stored1"}`, rec.Body.String())
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		body := `[{"correlation_id":"1","original_url":"https://stored.com"}]`
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(body)))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.JSONEq(t, `[{"correlation_id":"1","short_url":"http://localhost:8080/stored1","status":"existing"}]`, rec.Body.String())
+	})
+
+	t.Run("batch with new, existing and invalid items", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		body := `[
+			{"correlation_id":"1","original_url":"https://stored.com"},
+			{"correlation_id":"2","original_url":"https://new.com"},
+			{"correlation_id":"3","original_url":"not-a-url"}
+		]`
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(body)))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+
+		var resp []models.BatchURLResponse
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Len(t, resp, 3)
+		assert.Equal(t, models.BatchURLResponse{CorrelationID: "1", ShortURL: "http://localhost:8080/stored1", Status: models.BatchStatusExisting}, resp[0])
+		assert.Equal(t, models.BatchStatusCreated, resp[1].Status)
+		assert.Equal(t, models.BatchURLResponse{CorrelationID: "3", Status: models.BatchStatusInvalid, Error: "invalid url"}, resp[2])
+	})
+}
+
+func TestPostAlias(t *testing.T) {
+	logger.Sugar = sugar
+
+	cfg := config.Config{BaseURL: "http://localhost:8080"}
+	store := storage.NewMemoryStorage()
+	r := chi.NewRouter()
+	r.Use(logger.CookieMiddleware)
+	r.Post("/", PostHandler(cfg, store))
+	r.Post("/api/shorten", PostBodyHandler(cfg, store))
+	r.Post("/api/shorten/batch", PostBatchHandler(cfg, store))
+	r.Get("/{id}", GetHandler(cfg, store, nil, nil))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://sale.com","alias":"spring-sale"}`)))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.JSONEq(t, `{"result":"http://localhost:8080/spring-sale"}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/spring-sale", nil))
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Equal(t, "https://sale.com", rec.Header().Get("Location"))
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/?alias=spring-sale", strings.NewReader("https://other.com")))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/?alias=API", strings.NewReader("https://other.com")))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	body := `[
+		{"correlation_id":"1","original_url":"https://a.com","alias":"promo-a"},
+		{"correlation_id":"2","original_url":"https://b.com","alias":"spring-sale"},
+		{"correlation_id":"3","original_url":"https://c.com","alias":"no way"}
+	]`
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(body)))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	var resp []models.BatchURLResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, models.BatchURLResponse{CorrelationID: "1", ShortURL: "http://localhost:8080/promo-a", Status: models.BatchStatusCreated}, resp[0])
+	assert.Equal(t, models.BatchURLResponse{CorrelationID: "2", Status: models.BatchStatusInvalid, Error: "alias is taken"}, resp[1])
+	assert.Equal(t, models.BatchURLResponse{CorrelationID: "3", Status: models.BatchStatusInvalid, Error: "invalid alias"}, resp[2])
+}
+
+func TestExpiringLink(t *testing.T) {
+	logger.Sugar = sugar
+
+	cfg := config.Config{BaseURL: "http://localhost:8080"}
+	store := storage.NewMemoryStorage()
+	r := chi.NewRouter()
+	r.Use(logger.CookieMiddleware)
+	r.Post("/", PostHandler(cfg, store))
+	r.Post("/api/shorten", PostBodyHandler(cfg, store))
+	r.Get("/{id}", GetHandler(cfg, store, nil, nil))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://campaign.com","ttl":3600}`)))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	var resp models.Response
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	code := strings.TrimPrefix(resp.Result, cfg.BaseURL+"/")
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+code, nil))
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+
+	// срок истёк, а reaper ещё не успел пометить ссылку удалённой
+	past := time.Now().Add(-time.Second)
+	err := store.SaveURL(context.Background(), &models.URLData{OriginalURL: "https://old.com", ShortURL: "expired1", ExpiresAt: &past})
+	assert.NoError(t, err)
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/expired1", nil))
+	assert.Equal(t, http.StatusGone, rec.Code)
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/?ttl=-5", strings.NewReader("https://other.com")))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://other.com","expires_at":"2000-01-01T00:00:00Z"}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestOneTimeLink(t *testing.T) {
+	logger.Sugar = sugar
+
+	cfg := config.Config{BaseURL: "http://localhost:8080"}
+	store := storage.NewMemoryStorage()
+	clickStore := analytics.NewMemoryStore()
+	clicks := analytics.NewRecorder(clickStore, analytics.RecorderOptions{})
+	r := chi.NewRouter()
+	r.Use(logger.CookieMiddleware)
+	r.Post("/", PostHandler(cfg, store))
+	r.Get("/{id}", GetHandler(cfg, store, clicks, nil))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/?max_clicks=1&alias=invite", strings.NewReader("https://invite.com")))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	stored, err := store.GetURL(context.Background(), "invite")
+	assert.NoError(t, err)
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/invite", nil))
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/invite", nil))
+	assert.Equal(t, http.StatusGone, rec.Code)
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/?max_clicks=-1", strings.NewReader("https://other.com")))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// записан только состоявшийся переход, под кодом, а не алиасом
+	clicks.Close()
+	if assert.Len(t, clickStore.Clicks(), 1) {
+		assert.Equal(t, stored.ShortURL, clickStore.Clicks()[0].ShortURL)
+	}
+}
+
+func TestPasswordProtectedLink(t *testing.T) {
+	logger.Sugar = sugar
+	passwordCost = bcrypt.MinCost
+	defer func() { passwordCost = bcrypt.DefaultCost }()
+
+	cfg := config.Config{BaseURL: "http://localhost:8080"}
+	store := storage.NewMemoryStorage()
+	r := chi.NewRouter()
+	r.Use(logger.CookieMiddleware)
+	r.Post("/api/shorten", PostBodyHandler(cfg, store))
+	r.Get("/*", GetHandler(cfg, store, nil, nil))
+	r.Post("/*", PostPasswordHandler(cfg, store, nil, nil))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten",
+		strings.NewReader(`{"url":"https://docs.internal","alias":"docs","password":"s3cret","max_clicks":1}`)))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("content-type"), "text/html")
+	assert.Contains(t, rec.Body.String(), `name="password"`)
+	assert.Empty(t, rec.Header().Get("Location"))
+
+	unlockFrom := func(ip, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/docs", strings.NewReader("password="+password))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+	unlock := func(password string) *httptest.ResponseRecorder {
+		return unlockFrom("192.0.2.1", password)
+	}
+
+	rec = unlock("wrong")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Empty(t, rec.Header().Get("Location"))
+
+	rec = unlock("s3cret")
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "https://docs.internal", rec.Header().Get("Location"))
+
+	// верный пароль не обходит лимит переходов
+	rec = unlock("s3cret")
+	assert.Equal(t, http.StatusGone, rec.Code)
+
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusUnauthorized, unlock("wrong").Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, unlock("wrong").Code)
+	assert.Equal(t, http.StatusUnauthorized, unlockFrom("192.0.2.2", "wrong").Code)
+
+	// смена IP не обходит общий лимит ссылки
+	for i := 3; i < 50; i++ {
+		unlockFrom(fmt.Sprintf("192.0.2.%d", i), "wrong")
+	}
+	assert.Equal(t, http.StatusTooManyRequests, unlockFrom("192.0.2.200", "wrong").Code)
+}
+
+func TestPatchURL(t *testing.T) {
+	logger.Sugar = sugar
+
+	cfg := config.Config{BaseURL: "http://localhost:8080"}
+	store := storage.NewMemoryStorage()
+	assert.NoError(t, store.SaveURL(context.Background(), &models.URLData{OriginalURL: "https://other.com", ShortURL: "other", UserID: "someone"}))
+	r := chi.NewRouter()
+	r.Use(logger.CookieMiddleware)
+	r.Post("/api/shorten", PostBodyHandler(cfg, store))
+	r.Patch("/api/user/urls/{short}", PatchURLHandler(cfg, store))
+	r.Get("/api/user/urls/{short}/history", GetURLHistoryHandler(cfg, store))
+	r.Get("/*", GetHandler(cfg, store, nil, nil))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://v1.com","alias":"promo"}`)))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	cookies := rec.Result().Cookies()
+
+	send := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec = send(http.MethodPatch, "/api/user/urls/promo", `{"url":"https://v2.com"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp models.ShortURLData
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "http://localhost:8080/promo", resp.ShortURL)
+	assert.Equal(t, "https://v2.com", resp.OriginalURL)
+
+	rec = send(http.MethodGet, "/promo", "")
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Equal(t, "https://v2.com", rec.Header().Get("Location"))
+
+	rec = send(http.MethodGet, "/api/user/urls/promo/history", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var history []models.URLHistory
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &history))
+	assert.Len(t, history, 1)
+	assert.Equal(t, "https://v1.com", history[0].OriginalURL)
+
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPatch, "/api/user/urls/promo", `{"url":"not-a-url"}`).Code)
+	assert.Equal(t, http.StatusConflict, send(http.MethodPatch, "/api/user/urls/promo", `{"url":"https://other.com"}`).Code)
+	assert.Equal(t, http.StatusNotFound, send(http.MethodPatch, "/api/user/urls/other", `{"url":"https://v3.com"}`).Code)
+	assert.Equal(t, http.StatusNotFound, send(http.MethodGet, "/api/user/urls/other/history", "").Code)
+}
+
+func TestRestoreByList(t *testing.T) {
+	logger.Sugar = sugar
+
+	cfg := config.Config{BaseURL: "http://localhost:8080", RestorePeriod: time.Hour}
+	store := storage.NewMemoryStorage()
+	r := chi.NewRouter()
+	r.Use(logger.CookieMiddleware)
+	r.Post("/api/shorten", PostBodyHandler(cfg, store))
+	queue, err := deletion.New(store, deletion.Options{})
+	assert.NoError(t, err)
+	r.Delete("/api/user/urls", DeleteByList(cfg, queue))
+	r.Post("/api/user/urls/restore", RestoreByList(cfg, store))
+	r.Post("/api/user/urls/restore-disabled", RestoreByList(config.Config{}, store))
+	r.Get("/api/user/urls", GetByUserHandler(cfg, store))
+	r.Get("/*", GetHandler(cfg, store, nil, nil))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://restore.com","alias":"oops"}`)))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	cookies := rec.Result().Cookies()
+	stored, err := store.GetURL(context.Background(), "oops")
+	assert.NoError(t, err)
+
+	send := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusAccepted, send(http.MethodDelete, "/api/user/urls", `["`+stored.ShortURL+`"]`).Code)
+	assert.NoError(t, queue.Flush(context.Background()))
+	assert.Equal(t, http.StatusGone, send(http.MethodGet, "/oops", "").Code)
+
+	// удалённую ссылку владелец видит в списке с пометкой, её можно восстановить
+	listed := func() models.ShortURLData {
+		rec := send(http.MethodGet, "/api/user/urls", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var urls []models.ShortURLData
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &urls))
+		assert.Len(t, urls, 1)
+		return urls[0]
+	}
+	deleted := listed()
+	assert.True(t, deleted.IsDeleted)
+	assert.NotNil(t, deleted.DeletedAt)
+
+	assert.Equal(t, http.StatusForbidden, send(http.MethodPost, "/api/user/urls/restore-disabled", `["oops"]`).Code)
+	rec = send(http.MethodPost, "/api/user/urls/restore", `["oops"]`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `["`+stored.ShortURL+`"]`, rec.Body.String())
+	assert.Equal(t, http.StatusTemporaryRedirect, send(http.MethodGet, "/oops", "").Code)
+	restored := listed()
+	assert.False(t, restored.IsDeleted)
+	assert.Nil(t, restored.DeletedAt)
+
+	rec = send(http.MethodPost, "/api/user/urls/restore", `["oops"]`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[]`, rec.Body.String())
+}
+
+func TestPostStorageErrorIsNotConflict(t *testing.T) {
+	logger.Sugar = sugar
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	mock.ExpectExec("INSERT INTO urls").WillReturnError(context.DeadlineExceeded)
+
+	cfg := config.Config{BaseURL: "http://localhost:8080"}
+	r := chi.NewRouter()
+	r.Use(logger.CookieMiddleware)
+	r.Post("/", PostHandler(cfg, storage.NewPostgresStorage(db)))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://timeout.com")))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
+
+func TestPostResolvesHashCollision(t *testing.T) {
+	logger.Sugar = sugar
+
+	defaultShortener := shortener.Default
+	defer func() { shortener.Default = defaultShortener }()
+	gen := shortener.NewHashGenerator(0, "")
+	hash := gen.Hash
+	gen.Hash = func(data []byte) []byte {
+		switch string(data) {
+		case "https://first.com", "https://second.com", "https://third.com":
+			return []byte("forced collision")
+		}
+		return hash(data)
+	}
+	shortener.Default = &shortener.Shortener{Generator: gen, MaxAttempts: 10}
+
+	cfg := config.Config{BaseURL: "http://localhost:8080"}
+	store := storage.NewMemoryStorage()
+	r := chi.NewRouter()
+	r.Use(logger.CookieMiddleware)
+	r.Post("/", PostHandler(cfg, store))
+	r.Post("/api/shorten/batch", PostBatchHandler(cfg, store))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://first.com")))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	first := rec.Body.String()
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://second.com")))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	second := rec.Body.String()
+	assert.NotEqual(t, first, second)
+
+	rec = httptest.NewRecorder()
+	body := `[{"correlation_id":"1","original_url":"https://third.com"}]`
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(body)))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	var resp []models.BatchURLResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, models.BatchStatusCreated, resp[0].Status)
+	assert.NotContains(t, []string{first, second}, resp[0].ShortURL)
+
+	for url, short := range map[string]string{"https://first.com": first, "https://second.com": second} {
+		got, err := store.GetURL(context.Background(), strings.TrimPrefix(short, cfg.BaseURL+"/"))
+		assert.NoError(t, err)
+		assert.Equal(t, url, got.OriginalURL)
+	}
+}
+
+func TestURLStats(t *testing.T) {
+	logger.Sugar = sugar
+
+	cfg := config.Config{BaseURL: "http://localhost:8080"}
+	store := storage.NewMemoryStorage()
+	assert.NoError(t, store.SaveURL(context.Background(), &models.URLData{OriginalURL: "https://other.com", ShortURL: "other", UserID: "someone"}))
+	clickStore := analytics.NewMemoryStore()
+	r := chi.NewRouter()
+	r.Use(logger.CookieMiddleware)
+	r.Post("/api/shorten", PostBodyHandler(cfg, store))
+	r.Get("/api/user/urls/{short}/stats", GetURLStatsHandler(cfg, store, clickStore))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://stats.com","alias":"stats"}`)))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	cookies := rec.Result().Cookies()
+	stored, err := store.GetURL(context.Background(), "stats")
+	assert.NoError(t, err)
+
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, clickStore.SaveClicks(context.Background(), []models.Click{
+		{Time: day.Add(time.Hour), ShortURL: stored.ShortURL, IPHash: "v1", Referrer: "https://t.me/"},
+		{Time: day.Add(25 * time.Hour), ShortURL: stored.ShortURL, IPHash: "v1"},
+	}))
+
+	send := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec = send("/api/user/urls/stats/stats?from=2024-05-01&to=2024-05-03")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var stats models.LinkStats
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+	assert.Equal(t, 2, stats.TotalClicks)
+	assert.Equal(t, 1, stats.UniqueVisitors)
+	assert.Equal(t, []models.StatsPoint{{Time: day, Clicks: 1}, {Time: day.Add(24 * time.Hour), Clicks: 1}}, stats.Series)
+	assert.Equal(t, []models.StatsCount{{Value: "t.me", Count: 1}}, stats.TopReferrers)
+	assert.Equal(t, []models.StatsCount{{Value: "direct", Count: 1}, {Value: "social", Count: 1}}, stats.TopChannels)
+
+	rec = send("/api/user/urls/stats/stats?from=2024-05-01T00:00:00Z&to=2024-05-01T02:00:00Z&interval=hour")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+	assert.Len(t, stats.Series, 2)
+
+	assert.Equal(t, http.StatusBadRequest, send("/api/user/urls/stats/stats?from=yesterday").Code)
+	assert.Equal(t, http.StatusBadRequest, send("/api/user/urls/stats/stats?interval=week").Code)
+	assert.Equal(t, http.StatusBadRequest, send("/api/user/urls/stats/stats?from=2024-05-03&to=2024-05-01").Code)
+	assert.Equal(t, http.StatusNotFound, send("/api/user/urls/other/stats").Code)
+}
+
+func TestExportClicks(t *testing.T) {
+	logger.Sugar = sugar
+
+	cfg := config.Config{BaseURL: "http://localhost:8080"}
+	store := storage.NewMemoryStorage()
+	assert.NoError(t, store.SaveURL(context.Background(), &models.URLData{OriginalURL: "https://other.com", ShortURL: "other", UserID: "someone"}))
+	clickStore := analytics.NewMemoryStore()
+	r := chi.NewRouter()
+	r.Use(logger.CookieMiddleware)
+	r.Post("/api/shorten", PostBodyHandler(cfg, store))
+	r.Get("/api/user/urls/{short}/clicks/export", ExportURLClicksHandler(cfg, store, clickStore))
+	r.Get("/api/user/clicks/export", ExportUserClicksHandler(cfg, store, clickStore))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://export.com","alias":"exp"}`)))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	cookies := rec.Result().Cookies()
+	stored, err := store.GetURL(context.Background(), "exp")
+	assert.NoError(t, err)
+
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, clickStore.SaveClicks(context.Background(), []models.Click{
+		{Time: day, ShortURL: stored.ShortURL},
+		{Time: day.Add(48 * time.Hour), ShortURL: stored.ShortURL},
+		{Time: day.Add(48 * time.Hour), ShortURL: "other"},
+	}))
+
+	send := func(target, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec = send("/api/user/urls/exp/clicks/export", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", rec.Header().Get("content-type"))
+	assert.Equal(t, 3, strings.Count(rec.Body.String(), "\n"), "заголовок и две строки")
+
+	rec = send("/api/user/urls/exp/clicks/export?to=2024-05-02", "application/x-ndjson")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/x-ndjson", rec.Header().Get("content-type"))
+	assert.Equal(t, 1, strings.Count(rec.Body.String(), "\n"))
+
+	rec = send("/api/user/clicks/export?format=ndjson&from=2024-05-02", "text/csv")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/x-ndjson", rec.Header().Get("content-type"))
+	assert.Equal(t, 1, strings.Count(rec.Body.String(), "\n"))
+	assert.NotContains(t, rec.Body.String(), `"short_url":"other"`)
+
+	assert.Equal(t, http.StatusBadRequest, send("/api/user/urls/exp/clicks/export?format=xml", "").Code)
+	assert.Equal(t, http.StatusBadRequest, send("/api/user/urls/exp/clicks/export?from=2024-05-03&to=2024-05-01", "").Code)
+	assert.Equal(t, http.StatusNotFound, send("/api/user/urls/other/clicks/export", "").Code)
+
+	rec = send("/api/user/urls/exp/clicks/export?format=ndjson&from=2030-01-01", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/x-ndjson", rec.Header().Get("content-type"))
+	assert.Empty(t, rec.Body.String())
+}
+
+// failingClickStore не может прочитать переходы.
+type failingClickStore struct {
+	*analytics.MemoryStore
+}
+
+func (failingClickStore) ScanClicks(ctx context.Context, filter analytics.Filter, fn func(models.Click) error) error {
+	return errors.New("clicks file is unavailable")
+}
+
+func TestExportClicksScanError(t *testing.T) {
+	logger.Sugar = sugar
+
+	cfg := config.Config{BaseURL: "http://localhost:8080"}
+	store := storage.NewMemoryStorage()
+	r := chi.NewRouter()
+	r.Use(logger.CookieMiddleware)
+	r.Get("/api/user/clicks/export", ExportUserClicksHandler(cfg, store, failingClickStore{analytics.NewMemoryStore()}))
+
+	for _, format := range []string{"csv", "ndjson"} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/user/clicks/export?format="+format, nil))
+		assert.Equal(t, http.StatusInternalServerError, rec.Code, format)
+		assert.Empty(t, rec.Header().Get("Content-Disposition"), format)
+	}
+}
+
+// failingBatchStorage отвечает ошибкой на каждую ссылку пакета.
+type failingBatchStorage struct {
+	*storage.MemoryStorage
+	err error
+}
+
+func (s failingBatchStorage) SaveBatch(ctx context.Context, URLData []*models.URLData) ([]error, error) {
+	itemErrs := make([]error, len(URLData))
+	for i := range itemErrs {
+		itemErrs[i] = s.err
+	}
+	return itemErrs, nil
+}
+
+func TestPostBatchStorageErrorIsNotInvalid(t *testing.T) {
+	logger.Sugar = sugar
+
+	cfg := config.Config{BaseURL: "http://localhost:8080"}
+	store := failingBatchStorage{MemoryStorage: storage.NewMemoryStorage(), err: context.DeadlineExceeded}
+	r := chi.NewRouter()
+	r.Use(logger.CookieMiddleware)
+	r.Post("/api/shorten/batch", PostBatchHandler(cfg, store))
+
+	rec := httptest.NewRecorder()
+	body := `[{"correlation_id":"1","original_url":"https://timeout.com"},{"correlation_id":"2","original_url":"not a url"}]`
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(body)))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	var resp []models.BatchURLResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, models.BatchStatusError, resp[0].Status)
+	assert.Equal(t, models.BatchStatusInvalid, resp[1].Status)
+}
+
+func TestPostBatchTooManyCollisions(t *testing.T) {
+	logger.Sugar = sugar
+
+	defaultShortener := shortener.Default
+	defer func() { shortener.Default = defaultShortener }()
+	gen := shortener.NewHashGenerator(0, "")
+	hash := gen.Hash
+	gen.Hash = func(data []byte) []byte {
+		switch string(data) {
+		case "https://first.com", "https://second.com":
+			return []byte("forced collision")
+		}
+		return hash(data)
+	}
+	shortener.Default = &shortener.Shortener{Generator: gen, MaxAttempts: 1}
+
+	cfg := config.Config{BaseURL: "http://localhost:8080"}
+	store := storage.NewMemoryStorage()
+	r := chi.NewRouter()
+	r.Use(logger.CookieMiddleware)
+	r.Post("/", PostHandler(cfg, store))
+	r.Post("/api/shorten/batch", PostBatchHandler(cfg, store))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://first.com")))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = httptest.NewRecorder()
+	body := `[{"correlation_id":"1","original_url":"https://second.com"}]`
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(body)))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	var resp []models.BatchURLResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, models.BatchStatusError, resp[0].Status)
+	assert.Equal(t, "no free short url", resp[0].Error)
 }
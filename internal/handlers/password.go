@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var errInvalidPassword = errors.New("invalid password")
+
+// passwordCost — стоимость bcrypt, в тестах её понижают.
+var passwordCost = bcrypt.DefaultCost
+
+// hashPassword возвращает bcrypt-хеш пароля ссылки. Пустой пароль означает
+// ссылку без защиты.
+func hashPassword(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		return "", errInvalidPassword
+	}
+	return string(hash), err
+}
+
+func checkPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+var passwordForm = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Ссылка защищена паролем</title></head>
+<body>
+<form method="post">
+<p>Ссылка защищена паролем.</p>
+{{if .}}<p>{{.}}</p>{{end}}
+<input type="password" name="password" autofocus required>
+<button type="submit">Перейти</button>
+</form>
+</body>
+</html>
+`))
+
+func writePasswordForm(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("content-type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	passwordForm.Execute(w, message)
+}
+
+// attemptLimiter ограничивает перебор паролей: после maxFailures неверных
+// попыток для одного ключа следующие отклоняются, пока не пройдёт window
+// с первой ошибки.
+type attemptLimiter struct {
+	mu          sync.Mutex
+	maxFailures int
+	window      time.Duration
+	failures    map[string]*failedAttempts
+}
+
+type failedAttempts struct {
+	count int
+	first time.Time
+}
+
+func newAttemptLimiter(maxFailures int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{
+		maxFailures: maxFailures,
+		window:      window,
+		failures:    make(map[string]*failedAttempts),
+	}
+}
+
+func (l *attemptLimiter) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, ok := l.failures[key]
+	if !ok {
+		return true
+	}
+	if now.Sub(f.first) >= l.window {
+		delete(l.failures, key)
+		return true
+	}
+	return f.count < l.maxFailures
+}
+
+func (l *attemptLimiter) fail(key string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, ok := l.failures[key]
+	if !ok || now.Sub(f.first) >= l.window {
+		f = &failedAttempts{first: now}
+		l.failures[key] = f
+	}
+	f.count++
+	// устаревшие записи иначе удаляются только при следующем обращении
+	if len(l.failures) > 10000 {
+		for k, f := range l.failures {
+			if now.Sub(f.first) >= l.window {
+				delete(l.failures, k)
+			}
+		}
+	}
+}
+
+func (l *attemptLimiter) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, key)
+}
+
+// passwordLimiter считает неверные пароли отдельно для пары ссылка+клиент
+// и для ссылки целиком: смена IP не даёт бесконечного перебора одной ссылки.
+type passwordLimiter struct {
+	perClient *attemptLimiter
+	perLink   *attemptLimiter
+}
+
+func newPasswordLimiter(clientFailures, linkFailures int, window time.Duration) *passwordLimiter {
+	return &passwordLimiter{
+		perClient: newAttemptLimiter(clientFailures, window),
+		perLink:   newAttemptLimiter(linkFailures, window),
+	}
+}
+
+func (l *passwordLimiter) allow(shortURL, ip string, now time.Time) bool {
+	return l.perClient.allow(shortURL+"|"+ip, now) && l.perLink.allow(shortURL, now)
+}
+
+func (l *passwordLimiter) fail(shortURL, ip string, now time.Time) {
+	l.perClient.fail(shortURL+"|"+ip, now)
+	l.perLink.fail(shortURL, now)
+}
+
+// success сбрасывает только счётчик клиента: верный пароль одного
+// посетителя не должен обнулять бюджет ошибок всей ссылки.
+func (l *passwordLimiter) success(shortURL, ip string) {
+	l.perClient.reset(shortURL + "|" + ip)
+}
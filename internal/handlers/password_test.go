@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashPassword(t *testing.T) {
+	passwordCost = bcrypt.MinCost
+	defer func() { passwordCost = bcrypt.DefaultCost }()
+
+	hash, err := hashPassword("")
+	assert.NoError(t, err)
+	assert.Empty(t, hash)
+
+	hash, err = hashPassword("s3cret")
+	assert.NoError(t, err)
+	assert.NotContains(t, hash, "s3cret")
+	assert.True(t, checkPassword(hash, "s3cret"))
+	assert.False(t, checkPassword(hash, "wrong"))
+
+	_, err = hashPassword(strings.Repeat("a", 73))
+	assert.ErrorIs(t, err, errInvalidPassword)
+}
+
+func TestAttemptLimiter(t *testing.T) {
+	now := time.Now()
+	l := newAttemptLimiter(2, time.Minute)
+
+	assert.True(t, l.allow("key", now))
+	l.fail("key", now)
+	assert.True(t, l.allow("key", now))
+	l.fail("key", now)
+	assert.False(t, l.allow("key", now))
+	assert.True(t, l.allow("other", now))
+	assert.True(t, l.allow("key", now.Add(time.Minute)))
+
+	l.fail("key", now)
+	l.reset("key")
+	assert.True(t, l.allow("key", now))
+}
+
+func TestPasswordLimiter(t *testing.T) {
+	now := time.Now()
+	l := newPasswordLimiter(2, 3, time.Minute)
+
+	l.fail("docs", "1.1.1.1", now)
+	l.fail("docs", "1.1.1.1", now)
+	assert.False(t, l.allow("docs", "1.1.1.1", now))
+	assert.True(t, l.allow("docs", "2.2.2.2", now))
+
+	// бюджет ссылки общий для всех клиентов
+	l.fail("docs", "2.2.2.2", now)
+	assert.False(t, l.allow("docs", "3.3.3.3", now))
+	assert.True(t, l.allow("other", "3.3.3.3", now))
+
+	l.success("docs", "2.2.2.2")
+	assert.False(t, l.allow("docs", "2.2.2.2", now))
+	assert.True(t, l.allow("docs", "3.3.3.3", now.Add(time.Minute)))
+}
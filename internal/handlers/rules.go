@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/thalq/url-service/internal/classifier"
+	"github.com/thalq/url-service/internal/geoip"
+	"github.com/thalq/url-service/internal/models"
+)
+
+var (
+	errInvalidRules = errors.New("invalid rules")
+	errNoGeoIP      = errors.New("country rules require GeoIP database")
+)
+
+// maxRules ограничивает число правил одной ссылки.
+const maxRules = 20
+
+var ruleDevices = map[string]bool{
+	classifier.DeviceDesktop: true,
+	classifier.DeviceMobile:  true,
+	classifier.DeviceTablet:  true,
+}
+
+// validateRules проверяет правила редиректа и приводит значения условий
+// к виду, в котором их сравнивает ruleMatches: устройства и языки —
+// в нижнем регистре, страны — в верхнем. Без базы GeoIP (geoEnabled == false)
+// страну посетителя не определить, поэтому правила по стране отклоняются.
+func validateRules(rules []models.RedirectRule, geoEnabled bool) ([]models.RedirectRule, error) {
+	if len(rules) > maxRules {
+		return nil, errInvalidRules
+	}
+	var result []models.RedirectRule
+	for _, rule := range rules {
+		if !ifValidURL(rule.URL) {
+			return nil, errInvalidRules
+		}
+		if len(rule.Device)+len(rule.OS)+len(rule.Country)+len(rule.Language) == 0 {
+			// правило без условий подходит всем, для этого есть OriginalURL
+			return nil, errInvalidRules
+		}
+		normalized := models.RedirectRule{URL: rule.URL}
+		for _, d := range rule.Device {
+			d = strings.ToLower(strings.TrimSpace(d))
+			if !ruleDevices[d] {
+				return nil, errInvalidRules
+			}
+			normalized.Device = append(normalized.Device, d)
+		}
+		for _, os := range rule.OS {
+			os = strings.TrimSpace(os)
+			if os == "" {
+				return nil, errInvalidRules
+			}
+			normalized.OS = append(normalized.OS, os)
+		}
+		if len(rule.Country) > 0 && !geoEnabled {
+			return nil, errNoGeoIP
+		}
+		for _, c := range rule.Country {
+			c = strings.ToUpper(strings.TrimSpace(c))
+			if len(c) != 2 || !isLetters(c) {
+				return nil, errInvalidRules
+			}
+			normalized.Country = append(normalized.Country, c)
+		}
+		for _, l := range rule.Language {
+			l = strings.ToLower(strings.TrimSpace(l))
+			if l == "" || !isLetters(strings.ReplaceAll(l, "-", "")) {
+				return nil, errInvalidRules
+			}
+			normalized.Language = append(normalized.Language, l)
+		}
+		result = append(result, normalized)
+	}
+	return result, nil
+}
+
+func isLetters(s string) bool {
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// visitor — то, по чему правила выбирают адрес.
+type visitor struct {
+	device   string
+	os       string
+	country  string
+	language string
+}
+
+func newVisitor(r *http.Request, ip string, geo *geoip.DB) visitor {
+	agent := classifier.ParseUserAgent(r.UserAgent())
+	return visitor{
+		device:   agent.Device,
+		os:       agent.OS,
+		country:  geo.Lookup(ip).Country,
+		language: preferredLanguage(r.Header.Get("Accept-Language")),
+	}
+}
+
+// preferredLanguage возвращает язык с наибольшим q из Accept-Language,
+// при равных q — первый. Без подходящих языков — пустая строка.
+func preferredLanguage(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
+
+func ruleMatches(rule models.RedirectRule, v visitor) bool {
+	if len(rule.Device) > 0 && !containsFold(rule.Device, v.device) {
+		return false
+	}
+	if len(rule.OS) > 0 && !containsFold(rule.OS, v.os) {
+		return false
+	}
+	if len(rule.Country) > 0 && !containsFold(rule.Country, v.country) {
+		return false
+	}
+	if len(rule.Language) > 0 && !languageMatches(rule.Language, v.language) {
+		return false
+	}
+	return true
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if value != "" && strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// languageMatches: язык «en» в правиле подходит к en, en-us и en-gb,
+// а «en-us» — только к en-us.
+func languageMatches(languages []string, language string) bool {
+	for _, l := range languages {
+		if language == l || strings.HasPrefix(language, l+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// destination возвращает адрес редиректа и имя выпавшего варианта:
+// первое подошедшее правило ссылки, иначе вариант A/B-теста, иначе
+// OriginalURL. Выбранный вариант запоминается в cookie через w, поэтому
+// destination вызывается до записи заголовка ответа.
+func destination(w http.ResponseWriter, r *http.Request, URLData models.URLData, ip string, geo *geoip.DB) (string, string) {
+	if len(URLData.Rules) > 0 {
+		v := newVisitor(r, ip, geo)
+		for _, rule := range URLData.Rules {
+			if ruleMatches(rule, v) {
+				return rule.URL, ""
+			}
+		}
+	}
+	if len(URLData.Variants) > 0 {
+		variant := chooseVariant(w, r, URLData)
+		return variant.URL, variant.Name
+	}
+	return URLData.OriginalURL, ""
+}
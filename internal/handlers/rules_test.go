@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+	"github.com/thalq/url-service/config"
+	"github.com/thalq/url-service/internal/geoip"
+	logger "github.com/thalq/url-service/internal/middleware"
+	"github.com/thalq/url-service/internal/models"
+	"github.com/thalq/url-service/internal/storage"
+)
+
+const (
+	desktopUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36"
+	iPhoneUA  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1"
+	androidUA = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Mobile Safari/537.36"
+)
+
+func TestValidateRules(t *testing.T) {
+	rules, err := validateRules([]models.RedirectRule{
+		{Device: []string{" Mobile"}, Country: []string{"ru"}, Language: []string{"EN-us"}, URL: "https://m.example.com"},
+	}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.RedirectRule{
+		{Device: []string{"mobile"}, Country: []string{"RU"}, Language: []string{"en-us"}, URL: "https://m.example.com"},
+	}, rules)
+
+	invalid := []models.RedirectRule{
+		{URL: "https://example.com"},
+		{Device: []string{"mobile"}, URL: "not a url"},
+		{Device: []string{"watch"}, URL: "https://example.com"},
+		{Country: []string{"RUS"}, URL: "https://example.com"},
+		{Language: []string{"*"}, URL: "https://example.com"},
+		{OS: []string{" "}, URL: "https://example.com"},
+	}
+	for _, rule := range invalid {
+		_, err := validateRules([]models.RedirectRule{rule}, true)
+		assert.ErrorIs(t, err, errInvalidRules, "%+v", rule)
+	}
+
+	tooMany := make([]models.RedirectRule, maxRules+1)
+	for i := range tooMany {
+		tooMany[i] = models.RedirectRule{Device: []string{"mobile"}, URL: "https://example.com"}
+	}
+	_, err = validateRules(tooMany, true)
+	assert.ErrorIs(t, err, errInvalidRules)
+
+	_, err = validateRules([]models.RedirectRule{{Country: []string{"RU"}, URL: "https://example.com"}}, false)
+	assert.ErrorIs(t, err, errNoGeoIP)
+	_, err = validateRules([]models.RedirectRule{{Device: []string{"mobile"}, URL: "https://example.com"}}, false)
+	assert.NoError(t, err)
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	assert.Equal(t, "", preferredLanguage(""))
+	assert.Equal(t, "ru-ru", preferredLanguage("ru-RU,ru;q=0.9,en;q=0.8"))
+	assert.Equal(t, "en", preferredLanguage("de;q=0.5, en"))
+	assert.Equal(t, "de", preferredLanguage("*, de;q=0.5, fr;q=0"))
+	assert.Equal(t, "fr", preferredLanguage("de;q=bad, fr;q=0.1"))
+}
+
+func TestRuleRedirect(t *testing.T) {
+	logger.Sugar = sugar
+
+	geoPath := "../geoip/testdata/GeoIP2-City-Test.mmdb"
+	geo, err := geoip.Open(geoPath)
+	assert.NoError(t, err)
+	defer geo.Close()
+
+	cfg := config.Config{BaseURL: "http://localhost:8080", GeoIPPath: geoPath}
+	store := storage.NewMemoryStorage()
+	r := chi.NewRouter()
+	r.Use(logger.CookieMiddleware)
+	r.Post("/api/shorten", PostBodyHandler(cfg, store))
+	r.Post("/api/shorten/nogeo", PostBodyHandler(config.Config{BaseURL: cfg.BaseURL}, store))
+	r.Get("/{id}", GetHandler(cfg, store, nil, geo))
+
+	body, _ := json.Marshal(models.Request{
+		URL:   "https://example.com",
+		Alias: "promo",
+		Rules: []models.RedirectRule{
+			{OS: []string{"ios"}, URL: "https://apps.apple.com/promo"},
+			{Device: []string{"mobile"}, URL: "https://m.example.com"},
+			{Country: []string{"gb"}, Language: []string{"en"}, URL: "https://example.co.uk"},
+			{Language: []string{"ru"}, URL: "https://example.ru"},
+		},
+	})
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten/nogeo", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	tests := []struct {
+		name     string
+		ua       string
+		ip       string
+		language string
+		want     string
+	}{
+		{name: "first rule wins", ua: iPhoneUA, language: "ru", want: "https://apps.apple.com/promo"},
+		{name: "device", ua: androidUA, want: "https://m.example.com"},
+		{name: "country and language", ua: desktopUA, ip: "81.2.69.160", language: "en-GB,en;q=0.9", want: "https://example.co.uk"},
+		{name: "country without language", ua: desktopUA, ip: "81.2.69.160", language: "de", want: "https://example.com"},
+		{name: "language prefix", ua: desktopUA, ip: "5.255.255.5", language: "ru-RU", want: "https://example.ru"},
+		{name: "fallback", ua: desktopUA, want: "https://example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/promo", nil)
+			req.Header.Set("User-Agent", tt.ua)
+			if tt.language != "" {
+				req.Header.Set("Accept-Language", tt.language)
+			}
+			if tt.ip != "" {
+				req.RemoteAddr = tt.ip + ":5000"
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+			assert.Equal(t, tt.want, rec.Header().Get("Location"))
+		})
+	}
+
+	body, _ = json.Marshal(models.Request{
+		URL:   "https://example.com",
+		Rules: []models.RedirectRule{{Device: []string{"fridge"}, URL: "https://example.com"}},
+	})
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/thalq/url-service/internal/analytics"
+)
+
+var errInvalidStatsRange = errors.New("invalid stats range")
+
+// defaultStatsPeriod — период статистики, если from не задан.
+const defaultStatsPeriod = 30 * 24 * time.Hour
+
+// queryStatsRange читает период [from, to) и шаг ряда interval (day или hour)
+// из query-параметров. Даты — RFC 3339 или YYYY-MM-DD. По умолчанию —
+// последние 30 дней по дням.
+func queryStatsRange(query url.Values, now time.Time) (from, to time.Time, interval string, err error) {
+	to = now
+	if v := query.Get("to"); v != "" {
+		if to, err = parseStatsTime(v); err != nil {
+			return from, to, interval, err
+		}
+	}
+	from = to.Add(-defaultStatsPeriod)
+	if v := query.Get("from"); v != "" {
+		if from, err = parseStatsTime(v); err != nil {
+			return from, to, interval, err
+		}
+	}
+	interval = query.Get("interval")
+	if interval == "" {
+		interval = analytics.IntervalDay
+	}
+	if interval != analytics.IntervalDay && interval != analytics.IntervalHour {
+		return from, to, interval, errInvalidStatsRange
+	}
+	return from, to, interval, nil
+}
+
+func parseStatsTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errInvalidStatsRange
+}
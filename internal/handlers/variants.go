@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/thalq/url-service/internal/models"
+)
+
+var errInvalidVariants = errors.New("invalid variants")
+
+const (
+	maxVariants       = 10
+	maxVariantWeight  = 1000
+	maxVariantNameLen = 32
+	// variantCookieAge — сколько посетитель видит один и тот же вариант.
+	variantCookieAge = 30 * 24 * time.Hour
+)
+
+// validateVariants проверяет варианты A/B-теста: имена уникальны и годятся
+// в значение cookie, адреса валидны, веса от 1 до maxVariantWeight.
+func validateVariants(variants []models.Variant) error {
+	if len(variants) > maxVariants {
+		return errInvalidVariants
+	}
+	names := make(map[string]bool, len(variants))
+	for _, v := range variants {
+		if !validVariantName(v.Name) || names[v.Name] {
+			return errInvalidVariants
+		}
+		names[v.Name] = true
+		if !ifValidURL(v.URL) || v.Weight < 1 || v.Weight > maxVariantWeight {
+			return errInvalidVariants
+		}
+	}
+	return nil
+}
+
+func validVariantName(name string) bool {
+	if name == "" || len(name) > maxVariantNameLen {
+		return false
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+func variantCookie(shortURL string) string {
+	return "ab_" + shortURL
+}
+
+// chooseVariant возвращает вариант, который посетитель уже видел, если
+// он есть в cookie, иначе выбирает вариант по весам и запоминает его.
+func chooseVariant(w http.ResponseWriter, r *http.Request, URLData models.URLData) models.Variant {
+	name := variantCookie(URLData.ShortURL)
+	if cookie, err := r.Cookie(name); err == nil {
+		for _, v := range URLData.Variants {
+			if v.Name == cookie.Value {
+				return v
+			}
+		}
+	}
+	total := 0
+	for _, v := range URLData.Variants {
+		total += v.Weight
+	}
+	variant := pickVariant(URLData.Variants, rand.IntN(total))
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    variant.Name,
+		Path:     "/",
+		MaxAge:   int(variantCookieAge.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return variant
+}
+
+// pickVariant возвращает вариант, на чей отрезок весов попадает n из
+// [0, сумма весов).
+func pickVariant(variants []models.Variant, n int) models.Variant {
+	for _, v := range variants {
+		if n < v.Weight {
+			return v
+		}
+		n -= v.Weight
+	}
+	return variants[len(variants)-1]
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+	"github.com/thalq/url-service/config"
+	"github.com/thalq/url-service/internal/analytics"
+	logger "github.com/thalq/url-service/internal/middleware"
+	"github.com/thalq/url-service/internal/models"
+	"github.com/thalq/url-service/internal/storage"
+)
+
+func TestValidateVariants(t *testing.T) {
+	assert.NoError(t, validateVariants(nil))
+	assert.NoError(t, validateVariants([]models.Variant{
+		{Name: "a", URL: "https://a.example.com", Weight: 1},
+		{Name: "b_2", URL: "https://b.example.com", Weight: maxVariantWeight},
+	}))
+
+	invalid := [][]models.Variant{
+		{{Name: "", URL: "https://a.example.com", Weight: 1}},
+		{{Name: "a b", URL: "https://a.example.com", Weight: 1}},
+		{{Name: "a", URL: "not a url", Weight: 1}},
+		{{Name: "a", URL: "https://a.example.com", Weight: 0}},
+		{{Name: "a", URL: "https://a.example.com", Weight: maxVariantWeight + 1}},
+		{{Name: "a", URL: "https://a.example.com", Weight: 1}, {Name: "a", URL: "https://b.example.com", Weight: 1}},
+	}
+	for _, variants := range invalid {
+		assert.ErrorIs(t, validateVariants(variants), errInvalidVariants, "%+v", variants)
+	}
+}
+
+func TestPickVariant(t *testing.T) {
+	variants := []models.Variant{{Name: "a", Weight: 1}, {Name: "b", Weight: 3}}
+	assert.Equal(t, "a", pickVariant(variants, 0).Name)
+	assert.Equal(t, "b", pickVariant(variants, 1).Name)
+	assert.Equal(t, "b", pickVariant(variants, 3).Name)
+}
+
+func TestVariantRedirect(t *testing.T) {
+	logger.Sugar = sugar
+
+	cfg := config.Config{BaseURL: "http://localhost:8080"}
+	store := storage.NewMemoryStorage()
+	clickStore := analytics.NewMemoryStore()
+	clicks := analytics.NewRecorder(clickStore, analytics.RecorderOptions{})
+	r := chi.NewRouter()
+	r.Use(logger.CookieMiddleware)
+	r.Post("/api/shorten", PostBodyHandler(cfg, store))
+	r.Get("/{id}", GetHandler(cfg, store, clicks, nil))
+
+	body, _ := json.Marshal(models.Request{
+		URL:   "https://example.com",
+		Alias: "landing",
+		Rules: []models.RedirectRule{{OS: []string{"iOS"}, URL: "https://apps.apple.com/landing"}},
+		Variants: []models.Variant{
+			{Name: "a", URL: "https://a.example.com", Weight: 1},
+			{Name: "b", URL: "https://b.example.com", Weight: 1},
+		},
+	})
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	stored, err := store.GetURL(context.Background(), "landing")
+	assert.NoError(t, err)
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/landing", nil))
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	first := rec.Header().Get("Location")
+	assert.Contains(t, []string{"https://a.example.com", "https://b.example.com"}, first)
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		// cookie привязана к коду, а не к alias
+		if c.Name == variantCookie(stored.ShortURL) {
+			cookie = c
+		}
+	}
+	if !assert.NotNil(t, cookie) {
+		return
+	}
+
+	// с cookie посетитель снова попадает на свой вариант
+	for i := 0; i < 10; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/landing", nil)
+		req.AddCookie(cookie)
+		rec = httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		assert.Equal(t, first, rec.Header().Get("Location"))
+	}
+
+	// подошедшее правило важнее вариантов
+	req := httptest.NewRequest(http.MethodGet, "/landing", nil)
+	req.Header.Set("User-Agent", iPhoneUA)
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, "https://apps.apple.com/landing", rec.Header().Get("Location"))
+
+	clicks.Close()
+	recorded := clickStore.Clicks()
+	if assert.Len(t, recorded, 12) {
+		assert.Equal(t, cookie.Value, recorded[0].Variant)
+		assert.Equal(t, cookie.Value, recorded[10].Variant)
+		assert.Empty(t, recorded[11].Variant)
+	}
+
+	body, _ = json.Marshal(models.Request{
+		URL:      "https://example.com",
+		Variants: []models.Variant{{Name: "a", URL: "https://a.example.com"}},
+	})
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
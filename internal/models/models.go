@@ -1,18 +1,82 @@
 package models
 
-import "github.com/golang-jwt/jwt/v4"
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
 
 type URLData struct {
-	OriginalURL   string `json:"original_url"`
-	ShortURL      string `json:"short_url"`
-	CorrelationID string `json:"correlation_id"`
-	UserID        string `json:"user_id"`
-	DeletedFlag   bool   `db:"is_deleted"`
+	OriginalURL   string     `json:"original_url"`
+	ShortURL      string     `json:"short_url"`
+	CorrelationID string     `json:"correlation_id"`
+	UserID        string     `json:"user_id"`
+	Alias         string     `json:"alias,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	MaxClicks     int        `json:"max_clicks,omitempty"`
+	Clicks        int        `json:"clicks,omitempty"`
+	PasswordHash  string     `json:"password_hash,omitempty"`
+	UpdatedAt     *time.Time `json:"updated_at,omitempty"`
+	DeletedFlag   bool       `json:"is_deleted,omitempty" db:"is_deleted"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty"`
+	// Rules проверяются по порядку при редиректе, первое совпавшее правило
+	// задаёт адрес вместо OriginalURL.
+	Rules []RedirectRule `json:"rules,omitempty"`
+	// Variants делят переходы, не попавшие под Rules, между адресами
+	// пропорционально весам.
+	Variants []Variant `json:"variants,omitempty"`
+	// History — прежние исходные URL. Заполняется только файловым и
+	// in-memory хранилищами, Postgres отдаёт её через GetURLHistory.
+	History []URLHistory `json:"history,omitempty"`
+}
+
+// RedirectRule — адрес перехода для посетителей с заданными устройством,
+// ОС, страной и языком. Пустое условие подходит всем, внутри условия
+// достаточно совпадения с любым из значений.
+type RedirectRule struct {
+	Device   []string `json:"device,omitempty"`
+	OS       []string `json:"os,omitempty"`
+	Country  []string `json:"country,omitempty"`
+	Language []string `json:"language,omitempty"`
+	URL      string   `json:"url"`
+}
+
+// Variant — вариант адреса для A/B-теста. Name записывается в переходы
+// и запоминается в cookie посетителя.
+type Variant struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// URLHistory — исходный URL, на который ссылка вела до момента ChangedAt.
+type URLHistory struct {
+	OriginalURL string    `json:"original_url"`
+	ChangedAt   time.Time `json:"changed_at"`
+}
+
+// Exhausted сообщает, что у ссылки с ограничением переходов их не осталось.
+func (u URLData) Exhausted() bool {
+	return u.MaxClicks > 0 && u.Clicks >= u.MaxClicks
+}
+
+// Expired сообщает, истёк ли срок жизни ссылки к моменту now.
+func (u URLData) Expired(now time.Time) bool {
+	return u.ExpiresAt != nil && !now.Before(*u.ExpiresAt)
 }
 
 type ShortURLData struct {
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+	ShortURL    string         `json:"short_url"`
+	OriginalURL string         `json:"original_url"`
+	ExpiresAt   *time.Time     `json:"expires_at,omitempty"`
+	MaxClicks   int            `json:"max_clicks,omitempty"`
+	Clicks      int            `json:"clicks,omitempty"`
+	Protected   bool           `json:"protected,omitempty"`
+	UpdatedAt   *time.Time     `json:"updated_at,omitempty"`
+	IsDeleted   bool           `json:"is_deleted,omitempty"`
+	DeletedAt   *time.Time     `json:"deleted_at,omitempty"`
+	Rules       []RedirectRule `json:"rules,omitempty"`
+	Variants    []Variant      `json:"variants,omitempty"`
 }
 
 type Claims struct {
@@ -20,8 +84,19 @@ type Claims struct {
 	UserID string `json:"user_id"`
 }
 
+// Request — запрос на сокращение. TTL задаёт срок жизни ссылки в секундах,
+// ExpiresAt — абсолютный момент истечения, MaxClicks — число переходов,
+// после которого ссылка перестаёт работать, Password — пароль, без которого
+// редирект не выполняется; все необязательны.
 type Request struct {
-	URL string `json:"url"`
+	URL       string         `json:"url"`
+	Alias     string         `json:"alias,omitempty"`
+	TTL       int64          `json:"ttl,omitempty"`
+	ExpiresAt *time.Time     `json:"expires_at,omitempty"`
+	MaxClicks int            `json:"max_clicks,omitempty"`
+	Password  string         `json:"password,omitempty"`
+	Rules     []RedirectRule `json:"rules,omitempty"`
+	Variants  []Variant      `json:"variants,omitempty"`
 }
 
 type Response struct {
@@ -29,13 +104,34 @@ type Response struct {
 }
 
 type BatchURLRequest struct {
-	CorrelationID string `json:"correlation_id"`
-	OriginalURL   string `json:"original_url"`
+	CorrelationID string         `json:"correlation_id"`
+	OriginalURL   string         `json:"original_url"`
+	Alias         string         `json:"alias,omitempty"`
+	TTL           int64          `json:"ttl,omitempty"`
+	ExpiresAt     *time.Time     `json:"expires_at,omitempty"`
+	MaxClicks     int            `json:"max_clicks,omitempty"`
+	Password      string         `json:"password,omitempty"`
+	Rules         []RedirectRule `json:"rules,omitempty"`
+	Variants      []Variant      `json:"variants,omitempty"`
 }
 
+const (
+	BatchStatusCreated  = "created"
+	BatchStatusExisting = "existing"
+	BatchStatusInvalid  = "invalid"
+	// BatchStatusError — ссылку не удалось сохранить по вине сервера.
+	BatchStatusError = "error"
+)
+
 type BatchURLResponse struct {
 	CorrelationID string `json:"correlation_id"`
-	ShortURL      string `json:"short_url"`
+	ShortURL      string `json:"short_url,omitempty"`
+	Status        string `json:"status"`
+	Error         string `json:"error,omitempty"`
+}
+
+type UpdateRequest struct {
+	URL string `json:"url"`
 }
 
 type DeleteRequest struct {
@@ -45,4 +141,72 @@ type DeleteRequest struct {
 type ChDelete struct {
 	UserID   string `json:"user_id"`
 	ShortURL string `json:"short_url"`
+	JobID    string `json:"job_id,omitempty"`
+	Attempts int    `json:"attempts,omitempty"`
+}
+
+// DeletionJob — состояние запроса DELETE /api/user/urls: статус
+// (pending, completed, failed или not_found) каждого кода и их количество.
+type DeletionJob struct {
+	ID        string            `json:"id"`
+	Pending   int               `json:"pending"`
+	Completed int               `json:"completed"`
+	Failed    int               `json:"failed"`
+	NotFound  int               `json:"not_found"`
+	URLs      map[string]string `json:"urls"`
+}
+
+// Click — переход по короткой ссылке. IP клиента хранится только в виде
+// хеша с солью. Browser, OS, Device, Bot, Source и Channel — результат
+// разбора UserAgent и Referrer пакетом classifier, Country, Region и City
+// определяются по IP из базы GeoIP, Variant — имя выпавшего варианта
+// A/B-теста.
+type Click struct {
+	Time           time.Time `json:"time"`
+	ShortURL       string    `json:"short_url"`
+	Referrer       string    `json:"referrer,omitempty"`
+	UserAgent      string    `json:"user_agent,omitempty"`
+	IPHash         string    `json:"ip_hash,omitempty"`
+	AcceptLanguage string    `json:"accept_language,omitempty"`
+	Browser        string    `json:"browser,omitempty"`
+	OS             string    `json:"os,omitempty"`
+	Device         string    `json:"device,omitempty"`
+	Bot            bool      `json:"bot,omitempty"`
+	Source         string    `json:"source,omitempty"`
+	Channel        string    `json:"channel,omitempty"`
+	Country        string    `json:"country,omitempty"`
+	Region         string    `json:"region,omitempty"`
+	City           string    `json:"city,omitempty"`
+	Variant        string    `json:"variant,omitempty"`
+}
+
+// LinkStats — статистика переходов по ссылке за период [From, To).
+type LinkStats struct {
+	ShortURL       string       `json:"short_url"`
+	From           time.Time    `json:"from"`
+	To             time.Time    `json:"to"`
+	Interval       string       `json:"interval"`
+	TotalClicks    int          `json:"total_clicks"`
+	UniqueVisitors int          `json:"unique_visitors"`
+	BotClicks      int          `json:"bot_clicks"`
+	Series         []StatsPoint `json:"series"`
+	TopReferrers   []StatsCount `json:"top_referrers"`
+	TopChannels    []StatsCount `json:"top_channels"`
+	TopBrowsers    []StatsCount `json:"top_browsers"`
+	TopOS          []StatsCount `json:"top_os"`
+	TopDevices     []StatsCount `json:"top_devices"`
+	TopCountries   []StatsCount `json:"top_countries"`
+	TopCities      []StatsCount `json:"top_cities"`
+	TopVariants    []StatsCount `json:"top_variants"`
+}
+
+// StatsPoint — число переходов за день или час, начинающийся в Time.
+type StatsPoint struct {
+	Time   time.Time `json:"time"`
+	Clicks int       `json:"clicks"`
+}
+
+type StatsCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
 }
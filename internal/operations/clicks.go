@@ -0,0 +1,78 @@
+package operations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/thalq/url-service/internal/models"
+)
+
+// InsertClicks вставляет пачку переходов одной транзакцией.
+func InsertClicks(ctx context.Context, db *sql.DB, clicks []models.Click) (err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	stmt, err := tx.PrepareContext(ctx,
+		"INSERT INTO clicks (short_url, clicked_at, referrer, user_agent, ip_hash, accept_language, "+
+			"browser, os, device, is_bot, source, channel, country, region, city, variant) "+
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, click := range clicks {
+		if _, err = stmt.ExecContext(ctx, click.ShortURL, click.Time, click.Referrer, click.UserAgent,
+			click.IPHash, click.AcceptLanguage, click.Browser, click.OS, click.Device, click.Bot,
+			click.Source, click.Channel, click.Country, click.Region, click.City, click.Variant); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteClicks удаляет переходы по ссылкам shortURLs.
+func DeleteClicks(ctx context.Context, db *sql.DB, shortURLs []string) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM clicks WHERE short_url = ANY($1)", shortURLs)
+	return err
+}
+
+// ScanClicks передаёт в fn переходы по ссылкам shortURLs в порядке времени.
+// Нулевые from и to не ограничивают период.
+func ScanClicks(ctx context.Context, db *sql.DB, shortURLs []string, from, to time.Time, fn func(models.Click) error) error {
+	query := "SELECT clicked_at, short_url, referrer, user_agent, ip_hash, accept_language, " +
+		"browser, os, device, is_bot, source, channel, country, region, city, variant FROM clicks WHERE short_url = ANY($1)"
+	args := []any{shortURLs}
+	if !from.IsZero() {
+		args = append(args, from)
+		query += fmt.Sprintf(" AND clicked_at >= $%d", len(args))
+	}
+	if !to.IsZero() {
+		args = append(args, to)
+		query += fmt.Sprintf(" AND clicked_at < $%d", len(args))
+	}
+	rows, err := db.QueryContext(ctx, query+" ORDER BY clicked_at", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var click models.Click
+		if err := rows.Scan(&click.Time, &click.ShortURL, &click.Referrer, &click.UserAgent,
+			&click.IPHash, &click.AcceptLanguage, &click.Browser, &click.OS, &click.Device, &click.Bot,
+			&click.Source, &click.Channel, &click.Country, &click.Region, &click.City, &click.Variant); err != nil {
+			return err
+		}
+		if err := fn(click); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -3,37 +3,95 @@ package operations
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"time"
 
 	logger "github.com/thalq/url-service/internal/middleware"
 	"github.com/thalq/url-service/internal/models"
 )
 
-func GetURLData(ctx context.Context, db *sql.DB, URL string) (models.URLData, error) {
-	row := db.QueryRowContext(ctx, "SELECT original_url, short_url, correlation_id, is_deleted FROM urls "+
-		"WHERE short_url = $1 OR original_url = $1", URL)
+// urlColumns — колонки, которые читает scanURLData, в том же порядке.
+const urlColumns = "original_url, short_url, correlation_id, user_id, is_deleted, COALESCE(alias, ''), expires_at, max_clicks, clicks, COALESCE(password_hash, ''), updated_at, deleted_at, rules, variants"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanURLData(row scanner) (models.URLData, error) {
 	var URLData models.URLData
-	err := row.Scan(&URLData.OriginalURL, &URLData.ShortURL, &URLData.CorrelationID, &URLData.DeletedFlag)
+	var expiresAt, updatedAt, deletedAt sql.NullTime
+	var rules, variants []byte
+	err := row.Scan(&URLData.OriginalURL, &URLData.ShortURL, &URLData.CorrelationID, &URLData.UserID,
+		&URLData.DeletedFlag, &URLData.Alias, &expiresAt, &URLData.MaxClicks, &URLData.Clicks,
+		&URLData.PasswordHash, &updatedAt, &deletedAt, &rules, &variants)
+	if err != nil {
+		return URLData, err
+	}
+	if expiresAt.Valid {
+		URLData.ExpiresAt = &expiresAt.Time
+	}
+	if updatedAt.Valid {
+		URLData.UpdatedAt = &updatedAt.Time
+	}
+	if deletedAt.Valid {
+		URLData.DeletedAt = &deletedAt.Time
+	}
+	if len(rules) > 0 {
+		if err := json.Unmarshal(rules, &URLData.Rules); err != nil {
+			return URLData, err
+		}
+	}
+	if len(variants) > 0 {
+		err = json.Unmarshal(variants, &URLData.Variants)
+	}
+	return URLData, err
+}
+
+// jsonArg — значение JSONB-колонки rules или variants: JSON списка или NULL,
+// если он пуст.
+func jsonArg[T any](items []T) (any, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(items)
+	return string(data), err
+}
+
+func GetURLData(ctx context.Context, db *sql.DB, URL string) (models.URLData, error) {
+	row := db.QueryRowContext(ctx, "SELECT "+urlColumns+" FROM urls "+
+		"WHERE short_url = $1 OR alias = $1", URL)
+	URLData, err := scanURLData(row)
 	if err != nil {
 		logger.Sugar.Errorf("Failed to get URL: %v from database", err)
 		return URLData, err
 	}
-	logger.Sugar.Infof("Got URLData: %s from database", URLData)
+	logger.Sugar.Infof("Got URL %s from database", URLData.ShortURL)
 	return URLData, nil
 }
 
-func GetUserURLData(ctx context.Context, db *sql.DB, userID string) ([]models.ShortURLData, error) {
-	rows, err := db.QueryContext(ctx, "SELECT original_url, short_url FROM urls "+
+func GetURLDataByOriginal(ctx context.Context, db *sql.DB, originalURL string) (models.URLData, error) {
+	row := db.QueryRowContext(ctx, "SELECT "+urlColumns+" FROM urls "+
+		"WHERE original_url = $1", originalURL)
+	URLData, err := scanURLData(row)
+	if err != nil {
+		logger.Sugar.Errorf("Failed to get URL by original: %v from database", err)
+		return URLData, err
+	}
+	return URLData, nil
+}
+
+func GetUserURLData(ctx context.Context, db *sql.DB, userID string) ([]models.URLData, error) {
+	rows, err := db.QueryContext(ctx, "SELECT "+urlColumns+" FROM urls "+
 		"WHERE user_id = $1", userID)
 	if err != nil {
 		logger.Sugar.Errorf("Failed to get URL: %v from database", err)
 		return nil, err
 	}
 	defer rows.Close()
-	var URLData []models.ShortURLData
+	var URLData []models.URLData
 
 	for rows.Next() {
-		var data models.ShortURLData
-		err := rows.Scan(&data.OriginalURL, &data.ShortURL)
+		data, err := scanURLData(rows)
 		if err != nil {
 			logger.Sugar.Errorf("Failed to get URL: %v from database", err)
 			return nil, err
@@ -46,48 +104,303 @@ func GetUserURLData(ctx context.Context, db *sql.DB, userID string) ([]models.Sh
 		return nil, err
 	}
 
-	logger.Sugar.Infof("Got URLData: %s from database", URLData)
+	logger.Sugar.Infof("Got %d URLs of user %s from database", len(URLData), userID)
 	return URLData, nil
 }
 
+// CodeExists проверяет, занят ли код — как сгенерированный short_url или как alias.
+func CodeExists(ctx context.Context, db *sql.DB, code string) (bool, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM urls WHERE short_url = $1 OR alias = $1)", code).Scan(&exists)
+	return exists, err
+}
+
+// AliasExists проверяет, занят ли код как alias. Совпадения сгенерированных
+// short_url ловит первичный ключ.
+func AliasExists(ctx context.Context, db *sql.DB, code string) (bool, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM urls WHERE alias = $1)", code).Scan(&exists)
+	return exists, err
+}
+
+// TakenCodes одним запросом ищет среди codes занятые коды: shortURLs —
+// совпавшие со сгенерированными short_url, aliases — совпавшие с alias.
+func TakenCodes(ctx context.Context, db *sql.DB, codes []string) (shortURLs, aliases map[string]bool, err error) {
+	rows, err := db.QueryContext(ctx, "SELECT short_url, COALESCE(alias, '') FROM urls "+
+		"WHERE short_url = ANY($1) OR alias = ANY($1)", codes)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	shortURLs, aliases = make(map[string]bool), make(map[string]bool)
+	for rows.Next() {
+		var shortURL, alias string
+		if err := rows.Scan(&shortURL, &alias); err != nil {
+			return nil, nil, err
+		}
+		shortURLs[shortURL] = true
+		if alias != "" {
+			aliases[alias] = true
+		}
+	}
+	return shortURLs, aliases, rows.Err()
+}
+
+// ExistingOriginalURLs возвращает те из originalURLs, что уже сокращены.
+func ExistingOriginalURLs(ctx context.Context, db *sql.DB, originalURLs []string) (map[string]bool, error) {
+	rows, err := db.QueryContext(ctx, "SELECT original_url FROM urls WHERE original_url = ANY($1)", originalURLs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var originalURL string
+		if err := rows.Scan(&originalURL); err != nil {
+			return nil, err
+		}
+		existing[originalURL] = true
+	}
+	return existing, rows.Err()
+}
+
+// ScanShortURLs по одному передаёт в fn сгенерированные коды всех ссылок.
+func ScanShortURLs(ctx context.Context, db *sql.DB, fn func(shortURL string) error) error {
+	rows, err := db.QueryContext(ctx, "SELECT short_url FROM urls")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var shortURL string
+		if err := rows.Scan(&shortURL); err != nil {
+			return err
+		}
+		if err := fn(shortURL); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
 func InsertURL(ctx context.Context, db *sql.DB, URLData *models.URLData) error {
-	_, err := db.ExecContext(ctx, "INSERT INTO urls (original_url, short_url, correlation_id, user_id) "+
-		"VALUES ($1, $2, $3, $4)", URLData.OriginalURL, URLData.ShortURL, URLData.CorrelationID, URLData.UserID)
+	rules, err := jsonArg(URLData.Rules)
+	if err != nil {
+		return err
+	}
+	variants, err := jsonArg(URLData.Variants)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "INSERT INTO urls (original_url, short_url, correlation_id, user_id, alias, expires_at, max_clicks, password_hash, rules, variants) "+
+		"VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, NULLIF($8, ''), $9, $10)", URLData.OriginalURL, URLData.ShortURL, URLData.CorrelationID,
+		URLData.UserID, URLData.Alias, URLData.ExpiresAt, URLData.MaxClicks, URLData.PasswordHash, rules, variants)
 	return err
 }
 
-func ExecInsertBatchURLs(ctx context.Context, db *sql.DB, URLData []*models.URLData) error {
-	tx, err := db.Begin()
+// ExecInsertBatchURLs вставляет ссылки одной транзакцией. Ссылки, нарушающие
+// уникальность original_url, short_url или alias, пропускаются, inserted[i] сообщает,
+// была ли вставлена i-я ссылка.
+func ExecInsertBatchURLs(ctx context.Context, db *sql.DB, URLData []*models.URLData) (inserted []bool, err error) {
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
 	stmt, err := tx.PrepareContext(ctx,
-		"INSERT INTO urls (original_url, short_url, correlation_id, user_id) "+
-			"VALUES ($1, $2, $3, $4)")
+		"INSERT INTO urls (original_url, short_url, correlation_id, user_id, alias, expires_at, max_clicks, password_hash, rules, variants) "+
+			"VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, NULLIF($8, ''), $9, $10) ON CONFLICT DO NOTHING")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer stmt.Close()
-	for _, data := range URLData {
-		_, err := stmt.ExecContext(ctx, data.OriginalURL, data.ShortURL, data.CorrelationID, data.UserID)
+	inserted = make([]bool, len(URLData))
+	for i, data := range URLData {
+		rules, err := jsonArg(data.Rules)
+		if err != nil {
+			return nil, err
+		}
+		variants, err := jsonArg(data.Variants)
+		if err != nil {
+			return nil, err
+		}
+		res, err := stmt.ExecContext(ctx, data.OriginalURL, data.ShortURL, data.CorrelationID, data.UserID, data.Alias, data.ExpiresAt,
+			data.MaxClicks, data.PasswordHash, rules, variants)
+		if err != nil {
+			return nil, err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		inserted[i] = affected > 0
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return inserted, nil
+}
+
+// RetargetURL меняет исходный URL ссылки владельца, а прежний сохраняет
+// в url_history. Удалённые и чужие ссылки не меняются: вернётся sql.ErrNoRows.
+func RetargetURL(ctx context.Context, db *sql.DB, userID, shortURL, originalURL string) (URLData models.URLData, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return URLData, err
+	}
+	defer func() {
 		if err != nil {
 			tx.Rollback()
-			return err
 		}
+	}()
+	row := tx.QueryRowContext(ctx, "SELECT "+urlColumns+" FROM urls "+
+		"WHERE short_url = $1 AND user_id = $2 AND NOT is_deleted FOR UPDATE", shortURL, userID)
+	URLData, err = scanURLData(row)
+	if err != nil {
+		return URLData, err
+	}
+	if URLData.OriginalURL == originalURL {
+		return URLData, tx.Commit()
 	}
-	err = tx.Commit()
+	if _, err = tx.ExecContext(ctx, "INSERT INTO url_history (short_url, original_url) VALUES ($1, $2)",
+		shortURL, URLData.OriginalURL); err != nil {
+		return URLData, err
+	}
+	var updatedAt time.Time
+	err = tx.QueryRowContext(ctx, "UPDATE urls SET original_url = $2, updated_at = now() "+
+		"WHERE short_url = $1 RETURNING updated_at", shortURL, originalURL).Scan(&updatedAt)
 	if err != nil {
-		return err
+		return URLData, err
+	}
+	if err = tx.Commit(); err != nil {
+		return URLData, err
 	}
-	return nil
+	URLData.OriginalURL = originalURL
+	URLData.UpdatedAt = &updatedAt
+	logger.Sugar.Infof("Retargeted URL %s to %s", shortURL, originalURL)
+	return URLData, nil
 }
 
-func UpdateURLData(ctx context.Context, DeleteURL models.ChDelete, tx *sql.Tx) error {
-	_, err := tx.ExecContext(ctx, "UPDATE urls SET is_deleted = true WHERE short_url = $1 AND user_id = $2", DeleteURL.ShortURL, DeleteURL.UserID)
+func GetURLHistory(ctx context.Context, db *sql.DB, shortURL string) ([]models.URLHistory, error) {
+	rows, err := db.QueryContext(ctx, "SELECT original_url, changed_at FROM url_history "+
+		"WHERE short_url = $1 ORDER BY id", shortURL)
 	if err != nil {
-		logger.Sugar.Errorf("Failed to update URL: %v", err)
-		return err
+		return nil, err
+	}
+	defer rows.Close()
+	var history []models.URLHistory
+	for rows.Next() {
+		var entry models.URLHistory
+		if err := rows.Scan(&entry.OriginalURL, &entry.ChangedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, entry)
+	}
+	return history, rows.Err()
+}
+
+// IncrementClicks засчитывает переход по ссылке. Проверка лимита и
+// увеличение счётчика идут одним UPDATE, поэтому параллельные переходы
+// не превысят max_clicks. false означает, что переходов не осталось.
+func IncrementClicks(ctx context.Context, db *sql.DB, shortURL string) (bool, error) {
+	res, err := db.ExecContext(ctx, "UPDATE urls SET clicks = clicks + 1 "+
+		"WHERE short_url = $1 AND (max_clicks = 0 OR clicks < max_clicks)", shortURL)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// ExpireURLs помечает удалёнными ссылки, срок жизни которых истёк к now.
+func ExpireURLs(ctx context.Context, db *sql.DB, now time.Time) (int64, error) {
+	res, err := db.ExecContext(ctx, "UPDATE urls SET is_deleted = true, deleted_at = $1 "+
+		"WHERE expires_at <= $1 AND NOT is_deleted", now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RestoreURLs снимает пометку удаления со ссылок пользователя, удалённых
+// не раньше since, если их срок жизни ещё не истёк. Возвращает коды
+// восстановленных ссылок.
+func RestoreURLs(ctx context.Context, db *sql.DB, userID string, shortURLs []string, since time.Time) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "UPDATE urls SET is_deleted = false, deleted_at = NULL "+
+		"WHERE user_id = $1 AND (short_url = ANY($2) OR alias = ANY($2)) AND is_deleted AND deleted_at >= $3 "+
+		"AND (expires_at IS NULL OR expires_at > now()) RETURNING short_url", userID, shortURLs, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var restored []string
+	for rows.Next() {
+		var shortURL string
+		if err := rows.Scan(&shortURL); err != nil {
+			return nil, err
+		}
+		restored = append(restored, shortURL)
+	}
+	return restored, rows.Err()
+}
+
+// PurgeDeleted физически удаляет ссылки, помеченные удалёнными раньше before,
+// вместе с их переходами и возвращает их коды. Одним запросом, чтобы код,
+// доставшийся потом новой ссылке, не унаследовал чужую статистику.
+func PurgeDeleted(ctx context.Context, db *sql.DB, before time.Time) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "WITH purged AS ("+
+		"DELETE FROM urls WHERE is_deleted AND deleted_at < $1 RETURNING short_url), "+
+		"purged_clicks AS (DELETE FROM clicks WHERE short_url IN (SELECT short_url FROM purged)) "+
+		"SELECT short_url FROM purged", before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var purged []string
+	for rows.Next() {
+		var shortURL string
+		if err := rows.Scan(&shortURL); err != nil {
+			return nil, err
+		}
+		purged = append(purged, shortURL)
+	}
+	return purged, rows.Err()
+}
+
+// DeleteUserURLs одним запросом помечает удалёнными ссылки пользователя
+// по коду или alias и возвращает те из shortURLs, что принадлежат
+// пользователю. Уже удалённые ссылки сохраняют прежний момент удаления,
+// чужие не затрагиваются.
+func DeleteUserURLs(ctx context.Context, db *sql.DB, userID string, shortURLs []string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "UPDATE urls SET is_deleted = true, "+
+		"deleted_at = CASE WHEN is_deleted THEN deleted_at ELSE now() END "+
+		"WHERE user_id = $1 AND (short_url = ANY($2) OR alias = ANY($2)) "+
+		"RETURNING short_url, COALESCE(alias, '')", userID, shortURLs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	requested := make(map[string]bool, len(shortURLs))
+	for _, shortURL := range shortURLs {
+		requested[shortURL] = true
+	}
+	var deleted []string
+	for rows.Next() {
+		var shortURL, alias string
+		if err := rows.Scan(&shortURL, &alias); err != nil {
+			return nil, err
+		}
+		// ссылку могли передать и кодом, и alias — вернём оба
+		if requested[shortURL] {
+			deleted = append(deleted, shortURL)
+		}
+		if alias != "" && requested[alias] {
+			deleted = append(deleted, alias)
+		}
 	}
-	logger.Sugar.Infof("Updated URL: %s", DeleteURL.ShortURL)
-	return nil
+	return deleted, rows.Err()
 }
@@ -1,34 +1,188 @@
 package routers
 
 import (
+	"context"
 	"net/http"
 	"net/http/pprof"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/thalq/url-service/config"
+	"github.com/thalq/url-service/internal/analytics"
 	database "github.com/thalq/url-service/internal/dataBase"
+	"github.com/thalq/url-service/internal/deletion"
+	"github.com/thalq/url-service/internal/geoip"
 	"github.com/thalq/url-service/internal/handlers"
 	internalMiddleware "github.com/thalq/url-service/internal/middleware"
+	"github.com/thalq/url-service/internal/shortener"
+	"github.com/thalq/url-service/internal/storage"
 )
 
-func NewRouter(cfg config.Config) http.Handler {
+func newStorage(cfg config.Config) storage.Storage {
+	if cfg.DatabaseDNS != "" {
+		if db := database.DBConnect(cfg); db != nil {
+			internalMiddleware.Sugar.Infoln("Using database storage")
+			return storage.NewPostgresStorage(db)
+		}
+	}
+	if cfg.FileStoragePath != "" {
+		store, err := storage.NewFileStorage(cfg.FileStoragePath)
+		if err != nil {
+			internalMiddleware.Sugar.Fatalf("Failed to load file storage: %v", err)
+		}
+		if cfg.CompactInterval > 0 {
+			store.StartCompaction(cfg.CompactInterval)
+		}
+		internalMiddleware.Sugar.Infoln("Using file storage:", cfg.FileStoragePath)
+		return store
+	}
+	internalMiddleware.Sugar.Infoln("Using in-memory storage")
+	return storage.NewMemoryStorage()
+}
+
+func newShortener(cfg config.Config, store storage.Storage) *shortener.Shortener {
+	s, err := shortener.New(cfg.ShortGenerator, cfg.ShortLength, cfg.ShortAlphabet)
+	if err != nil {
+		internalMiddleware.Sugar.Fatalf("Invalid short code settings: %v", err)
+	}
+	if counter, ok := s.Generator.(*shortener.CounterGenerator); ok {
+		// продолжаем после самого большого выданного номера: число ссылок
+		// меньше него, если часть удалена окончательно
+		err := store.ScanShortURLs(context.Background(), func(shortURL string) error {
+			counter.Observe(shortURL)
+			return nil
+		})
+		if err != nil {
+			internalMiddleware.Sugar.Fatalf("Failed to read stored short URLs: %v", err)
+		}
+	}
+	internalMiddleware.Sugar.Infoln("Using short code generator:", cfg.ShortGenerator)
+	return s
+}
+
+func newDeleteQueue(cfg config.Config, store storage.Storage) *deletion.Queue {
+	queue, err := deletion.New(store, deletion.Options{
+		JournalPath:   cfg.DeleteQueuePath,
+		BatchSize:     cfg.DeleteBatchSize,
+		FlushInterval: cfg.DeleteInterval,
+	})
+	if err != nil {
+		internalMiddleware.Sugar.Fatalf("Failed to open deletion queue: %v", err)
+	}
+	queue.Start()
+	return queue
+}
+
+func newClickStore(cfg config.Config, store storage.Storage) analytics.Store {
+	switch s := store.(type) {
+	case *storage.PostgresStorage:
+		return analytics.NewPostgresStore(s.DB())
+	case *storage.FileStorage:
+		clicks, err := analytics.NewFileStore(cfg.ClicksPath)
+		if err != nil {
+			internalMiddleware.Sugar.Fatalf("Failed to open clicks file: %v", err)
+		}
+		return clicks
+	}
+	return analytics.NewMemoryStore()
+}
+
+func newGeoIP(cfg config.Config) *geoip.DB {
+	if cfg.GeoIPPath == "" {
+		return nil
+	}
+	geo, err := geoip.Open(cfg.GeoIPPath)
+	if err != nil {
+		internalMiddleware.Sugar.Fatalf("Failed to open GeoIP database: %v", err)
+	}
+	internalMiddleware.Sugar.Infoln("Using GeoIP database:", cfg.GeoIPPath)
+	return geo
+}
+
+// newIPSalt возвращает соль для хешей IP: без неё хеш IPv4 обращается
+// перебором. Если соль не задана, для файлового хранилища она создаётся
+// рядом с файлом переходов, а в памяти живёт вместе с процессом. Для базы
+// её хранить негде, поэтому без CLICKS_IP_SALT сервер не запускается.
+func newIPSalt(cfg config.Config, store storage.Storage) string {
+	if cfg.IPHashSalt != "" {
+		return cfg.IPHashSalt
+	}
+	switch store.(type) {
+	case *storage.PostgresStorage:
+		internalMiddleware.Sugar.Fatal("Clicks IP salt is required with database storage, set CLICKS_IP_SALT or -ip-salt")
+	case *storage.FileStorage:
+		path := cfg.ClicksPath + ".salt"
+		salt, err := analytics.LoadSalt(path)
+		if err != nil {
+			internalMiddleware.Sugar.Fatalf("Failed to load clicks IP salt: %v", err)
+		}
+		internalMiddleware.Sugar.Infoln("Using clicks IP salt from", path)
+		return salt
+	}
+	salt, err := analytics.NewSalt()
+	if err != nil {
+		internalMiddleware.Sugar.Fatalf("Failed to generate clicks IP salt: %v", err)
+	}
+	return salt
+}
+
+func newClickRecorder(cfg config.Config, store storage.Storage, clicks analytics.Store, geo *geoip.DB) *analytics.Recorder {
+	return analytics.NewRecorder(clicks, analytics.RecorderOptions{
+		BufferSize:    cfg.ClicksBuffer,
+		FlushInterval: cfg.ClicksInterval,
+		IPSalt:        newIPSalt(cfg, store),
+		GeoIP:         geo,
+	})
+}
+
+// NewRouter собирает обработчики и фоновые воркеры. Возвращаемую функцию
+// нужно вызвать после остановки сервера: она выполняет отложенные удаления
+// и дописывает накопленные переходы.
+func NewRouter(cfg config.Config) (http.Handler, func()) {
 	r := chi.NewRouter()
 	r.Use(middleware.Recoverer)
 	r.Use(internalMiddleware.WithLogging)
 	r.Use(internalMiddleware.GzipMiddleware)
 	r.Use(internalMiddleware.CookieMiddleware)
 
-	db := database.DBConnect(cfg)
+	store := newStorage(cfg)
+	if cfg.ReapInterval > 0 {
+		storage.StartReaper(store, cfg.ReapInterval)
+	}
+	clickStore := newClickStore(cfg, store)
+	if cfg.Retention > 0 && cfg.PurgeInterval > 0 {
+		if cfg.Retention < cfg.RestorePeriod {
+			internalMiddleware.Sugar.Warnf("Retention %s is shorter than restore period %s", cfg.Retention, cfg.RestorePeriod)
+		}
+		// в Postgres переходы удаляются вместе со ссылками, а для файла
+		// и памяти их удаляет onPurge
+		var onPurge func(ctx context.Context, shortURLs []string) error
+		if _, ok := store.(*storage.PostgresStorage); !ok {
+			onPurge = clickStore.DeleteClicks
+		}
+		storage.StartPurger(store, cfg.PurgeInterval, cfg.Retention, onPurge)
+	}
+	shortener.Default = newShortener(cfg, store)
+	deleteQueue := newDeleteQueue(cfg, store)
+	geo := newGeoIP(cfg)
+	clicks := newClickRecorder(cfg, store, clickStore, geo)
 
 	r.Route("/", func(r chi.Router) {
-		r.Post("/", handlers.PostHandler(cfg, db))
-		r.Post("/api/shorten", handlers.PostBodyHandler(cfg, db))
-		r.Post("/api/shorten/batch", handlers.PostBatchHandler(cfg, db))
-		r.Get("/api/user/urls", handlers.GetByUserHandler(cfg, db))
-		r.Get("/*", handlers.GetHandler(cfg, db))
-		r.Get("/ping", handlers.GetPingHandler(cfg, db))
-		r.Delete("/api/user/urls", handlers.DeleteByList(cfg, db))
+		r.Post("/", handlers.PostHandler(cfg, store))
+		r.Post("/api/shorten", handlers.PostBodyHandler(cfg, store))
+		r.Post("/api/shorten/batch", handlers.PostBatchHandler(cfg, store))
+		r.Get("/api/user/urls", handlers.GetByUserHandler(cfg, store))
+		r.Patch("/api/user/urls/{short}", handlers.PatchURLHandler(cfg, store))
+		r.Get("/api/user/urls/{short}/history", handlers.GetURLHistoryHandler(cfg, store))
+		r.Get("/api/user/urls/{short}/stats", handlers.GetURLStatsHandler(cfg, store, clickStore))
+		r.Get("/api/user/urls/{short}/clicks/export", handlers.ExportURLClicksHandler(cfg, store, clickStore))
+		r.Get("/api/user/clicks/export", handlers.ExportUserClicksHandler(cfg, store, clickStore))
+		r.Get("/*", handlers.GetHandler(cfg, store, clicks, geo))
+		r.Post("/*", handlers.PostPasswordHandler(cfg, store, clicks, geo))
+		r.Get("/ping", handlers.GetPingHandler(cfg, store))
+		r.Delete("/api/user/urls", handlers.DeleteByList(cfg, deleteQueue))
+		r.Get("/api/user/deletions/{id}", handlers.GetDeletionHandler(cfg, deleteQueue))
+		r.Post("/api/user/urls/restore", handlers.RestoreByList(cfg, store))
 	})
 	r.Route("/debug/pprof", func(r chi.Router) {
 		r.HandleFunc("/", pprof.Index)
@@ -43,5 +197,10 @@ func NewRouter(cfg config.Config) http.Handler {
 		r.Handle("/mutex", pprof.Handler("mutex"))
 		r.Handle("/threadcreate", pprof.Handler("threadcreate"))
 	})
-	return r
+	return r, func() {
+		if err := deleteQueue.Close(); err != nil {
+			internalMiddleware.Sugar.Errorf("Failed to close deletion queue: %v", err)
+		}
+		clicks.Close()
+	}
 }
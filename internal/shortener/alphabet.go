@@ -0,0 +1,87 @@
+package shortener
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	AlphabetBase62 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	// AlphabetReadable — base62 без похожих друг на друга 0/O/o, 1/l/I.
+	AlphabetReadable = "23456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnpqrstuvwxyz"
+)
+
+var namedAlphabets = map[string]string{
+	"base62":   AlphabetBase62,
+	"readable": AlphabetReadable,
+}
+
+// parseAlphabet принимает имя алфавита или сами символы. Символы должны быть
+// безопасны в пути URL и не повторяться.
+func parseAlphabet(alphabet string) (string, error) {
+	if alphabet == "" {
+		return AlphabetBase62, nil
+	}
+	if named, ok := namedAlphabets[alphabet]; ok {
+		return named, nil
+	}
+	if len(alphabet) < 2 {
+		return "", fmt.Errorf("alphabet must contain at least 2 characters")
+	}
+	seen := make(map[rune]bool, len(alphabet))
+	for _, c := range alphabet {
+		isDigit := c >= '0' && c <= '9'
+		isLetter := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !isDigit && !isLetter && !strings.ContainsRune("-_", c) {
+			return "", fmt.Errorf("alphabet character %q is not allowed", c)
+		}
+		if seen[c] {
+			return "", fmt.Errorf("alphabet character %q is repeated", c)
+		}
+		seen[c] = true
+	}
+	return alphabet, nil
+}
+
+// decode — обратное к encode: число, записанное кодом по алфавиту. ok
+// ложно, если в коде есть символы не из алфавита или число не влезает
+// в uint64.
+func decode(code, alphabet string) (uint64, bool) {
+	if code == "" {
+		return 0, false
+	}
+	base := big.NewInt(int64(len(alphabet)))
+	n := new(big.Int)
+	for _, c := range code {
+		i := strings.IndexRune(alphabet, c)
+		if i < 0 {
+			return 0, false
+		}
+		n.Mul(n, base).Add(n, big.NewInt(int64(i)))
+	}
+	if !n.IsUint64() {
+		return 0, false
+	}
+	return n.Uint64(), true
+}
+
+// encode записывает число n в системе счисления по алфавиту,
+// дополняя слева нулевым символом до minLength.
+func encode(n *big.Int, alphabet string, minLength int) string {
+	base := big.NewInt(int64(len(alphabet)))
+	n = new(big.Int).Set(n)
+	mod := new(big.Int)
+	var digits []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, base, mod)
+		digits = append(digits, alphabet[mod.Int64()])
+	}
+	for len(digits) < minLength {
+		digits = append(digits, alphabet[0])
+	}
+	for i, j := 0, len(digits)-1; i < j; i, j = i+1, j-1 {
+		digits[i], digits[j] = digits[j], digits[i]
+	}
+	return string(digits)
+}
@@ -0,0 +1,118 @@
+package shortener
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"math/big"
+	"strconv"
+	"sync/atomic"
+)
+
+const defaultLength = 8
+
+// HashGenerator выводит код из хеша исходного URL. При коллизии код
+// выводится заново из того же URL с номером попытки, поэтому результат
+// детерминирован. Hash можно подменить, например в тестах.
+type HashGenerator struct {
+	Hash     func(data []byte) []byte
+	Length   int
+	Alphabet string
+}
+
+func sha256Hash(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+func NewHashGenerator(length int, alphabet string) *HashGenerator {
+	if length == 0 {
+		length = defaultLength
+	}
+	if alphabet == "" {
+		alphabet = AlphabetBase62
+	}
+	return &HashGenerator{Hash: sha256Hash, Length: length, Alphabet: alphabet}
+}
+
+// Code для нулевой попытки хеширует сам URL, для следующих — URL с номером попытки.
+func (g *HashGenerator) Code(originalURL string, attempt int) string {
+	input := originalURL
+	if attempt > 0 {
+		input += "#" + strconv.Itoa(attempt)
+	}
+	n := new(big.Int).SetBytes(g.Hash([]byte(input)))
+	code := encode(n, g.Alphabet, g.Length)
+	return code[len(code)-g.Length:]
+}
+
+// CounterGenerator выдаёт последовательные номера в системе счисления
+// по алфавиту: коды короткие и монотонно растут. Коды, уже занятые
+// в хранилище, пропускаются через обычный механизм коллизий.
+type CounterGenerator struct {
+	counter   atomic.Uint64
+	MinLength int
+	Alphabet  string
+}
+
+func NewCounterGenerator(minLength int, alphabet string) *CounterGenerator {
+	if alphabet == "" {
+		alphabet = AlphabetBase62
+	}
+	return &CounterGenerator{MinLength: minLength, Alphabet: alphabet}
+}
+
+// Seed продолжает счёт после n уже выданных кодов, например после рестарта.
+func (g *CounterGenerator) Seed(n uint64) {
+	g.counter.Store(n)
+}
+
+// Observe учитывает уже выданный код: следующие коды будут больше него.
+// Коды с символами не из алфавита пропускаются. Код, выданный другим
+// генератором, тоже сдвигает счёт — коды станут длиннее, но не совпадут
+// с занятыми.
+func (g *CounterGenerator) Observe(code string) {
+	n, ok := decode(code, g.Alphabet)
+	if !ok {
+		return
+	}
+	for {
+		current := g.counter.Load()
+		if n <= current || g.counter.CompareAndSwap(current, n) {
+			return
+		}
+	}
+}
+
+func (g *CounterGenerator) Code(originalURL string, attempt int) string {
+	n := g.counter.Add(1)
+	return encode(new(big.Int).SetUint64(n), g.Alphabet, g.MinLength)
+}
+
+// RandomGenerator выдаёт криптографически случайные коды, которые нельзя угадать.
+type RandomGenerator struct {
+	Length   int
+	Alphabet string
+}
+
+func NewRandomGenerator(length int, alphabet string) *RandomGenerator {
+	if length == 0 {
+		length = defaultLength
+	}
+	if alphabet == "" {
+		alphabet = AlphabetBase62
+	}
+	return &RandomGenerator{Length: length, Alphabet: alphabet}
+}
+
+func (g *RandomGenerator) Code(originalURL string, attempt int) string {
+	max := big.NewInt(int64(len(g.Alphabet)))
+	code := make([]byte, g.Length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(err)
+		}
+		code[i] = g.Alphabet[n.Int64()]
+	}
+	return string(code)
+}
@@ -1,22 +1,77 @@
 package shortener
 
 import (
-	"crypto/sha256"
-	"encoding/base64"
-	"encoding/hex"
+	"errors"
+	"fmt"
 )
 
-func GenerateShortString(s string) string {
-	h := sha256.New()
-	h.Write([]byte(s))
-	hashBytes := h.Sum(nil)
+var (
+	// ErrCollision возвращает функция сохранения, если код уже занят другой ссылкой.
+	ErrCollision         = errors.New("short url collision")
+	ErrTooManyCollisions = errors.New("too many short url collisions")
+)
 
-	hexString := hex.EncodeToString(hashBytes)
+const defaultMaxAttempts = 10
 
-	encodedString := base64.StdEncoding.EncodeToString([]byte(hexString))
+const (
+	KindHash    = "hash"
+	KindCounter = "counter"
+	KindRandom  = "random"
+)
+
+// Generator выдаёт короткий код для ссылки. attempt — номер попытки:
+// если код с предыдущей попытки оказался занят, генератор должен вернуть другой.
+type Generator interface {
+	Code(originalURL string, attempt int) string
+}
 
-	if len(encodedString) > 8 {
-		return encodedString[:8]
+type Shortener struct {
+	Generator
+	MaxAttempts int
+}
+
+// New собирает Shortener по виду генератора, длине кода и алфавиту.
+// Нулевая длина и пустой алфавит означают значения по умолчанию генератора.
+func New(kind string, length int, alphabet string) (*Shortener, error) {
+	alphabet, err := parseAlphabet(alphabet)
+	if err != nil {
+		return nil, err
 	}
-	return encodedString
+	if length < 0 {
+		return nil, fmt.Errorf("invalid short code length: %d", length)
+	}
+
+	var g Generator
+	switch kind {
+	case KindHash, "":
+		g = NewHashGenerator(length, alphabet)
+	case KindCounter:
+		g = NewCounterGenerator(length, alphabet)
+	case KindRandom:
+		g = NewRandomGenerator(length, alphabet)
+	default:
+		return nil, fmt.Errorf("unknown short code generator: %s", kind)
+	}
+	return &Shortener{Generator: g, MaxAttempts: defaultMaxAttempts}, nil
+}
+
+// Default используется хендлерами, при старте его настраивает роутер.
+var Default = &Shortener{Generator: NewHashGenerator(0, ""), MaxAttempts: defaultMaxAttempts}
+
+// Shorten перебирает коды, начиная с попытки from, пока save не сохранит
+// ссылку без коллизии. Любая другая ошибка save возвращается как есть
+// вместе с кодом, на котором она произошла.
+func (s *Shortener) Shorten(originalURL string, from int, save func(code string) error) (string, error) {
+	for attempt := from; attempt < s.MaxAttempts; attempt++ {
+		code := s.Code(originalURL, attempt)
+		err := save(code)
+		if !errors.Is(err, ErrCollision) {
+			return code, err
+		}
+	}
+	return "", ErrTooManyCollisions
+}
+
+func GenerateShortString(s string) string {
+	return Default.Code(s, 0)
 }
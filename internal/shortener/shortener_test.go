@@ -1,9 +1,163 @@
 package shortener
 
-import "testing"
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
 
 func BenchmarkShortener(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		GenerateShortString("https://www.google.com")
 	}
 }
+
+// collidingShortener сводит первые попытки для двух URL к одному хешу.
+func collidingShortener() *Shortener {
+	g := NewHashGenerator(0, "")
+	g.Hash = func(data []byte) []byte {
+		switch string(data) {
+		case "https://a.com", "https://b.com":
+			return []byte("same hash for both")
+		}
+		return sha256Hash(data)
+	}
+	return &Shortener{Generator: g, MaxAttempts: defaultMaxAttempts}
+}
+
+func TestGenerateShortString(t *testing.T) {
+	code := GenerateShortString("https://www.google.com")
+	assert.Len(t, code, 8)
+	assert.Equal(t, code, GenerateShortString("https://www.google.com"))
+	assert.NotEqual(t, code, GenerateShortString("https://www.google.ru"))
+}
+
+func TestShortener_Shorten(t *testing.T) {
+	s := collidingShortener()
+	assert.Equal(t, s.Code("https://a.com", 0), s.Code("https://b.com", 0))
+
+	taken := map[string]string{}
+	save := func(url string) func(code string) error {
+		return func(code string) error {
+			if owner, ok := taken[code]; ok && owner != url {
+				return ErrCollision
+			}
+			taken[code] = url
+			return nil
+		}
+	}
+
+	codeA, err := s.Shorten("https://a.com", 0, save("https://a.com"))
+	assert.NoError(t, err)
+	assert.Equal(t, s.Code("https://a.com", 0), codeA)
+
+	codeB, err := s.Shorten("https://b.com", 0, save("https://b.com"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, codeA, codeB)
+	assert.Equal(t, s.Code("https://b.com", 1), codeB, "re-derivation must be deterministic")
+}
+
+func TestShortener_ShortenErrors(t *testing.T) {
+	s, err := New(KindHash, 0, "")
+	assert.NoError(t, err)
+	s.MaxAttempts = 3
+
+	_, err = s.Shorten("https://a.com", 0, func(code string) error { return ErrCollision })
+	assert.ErrorIs(t, err, ErrTooManyCollisions)
+
+	other := errors.New("storage is down")
+	_, err = s.Shorten("https://a.com", 0, func(code string) error { return other })
+	assert.ErrorIs(t, err, other)
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		length   int
+		alphabet string
+		wantErr  bool
+	}{
+		{name: "default", kind: "", length: 0, alphabet: ""},
+		{name: "hash", kind: KindHash, length: 6, alphabet: "readable"},
+		{name: "counter", kind: KindCounter, length: 0, alphabet: "base62"},
+		{name: "random", kind: KindRandom, length: 12, alphabet: "abcdef"},
+		{name: "unknown kind", kind: "uuid", wantErr: true},
+		{name: "negative length", kind: KindHash, length: -1, wantErr: true},
+		{name: "unsafe alphabet", kind: KindHash, alphabet: "ab/c", wantErr: true},
+		{name: "repeated alphabet", kind: KindHash, alphabet: "abca", wantErr: true},
+		{name: "short alphabet", kind: KindHash, alphabet: "a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(tt.kind, tt.length, tt.alphabet)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotEmpty(t, s.Code("https://example.com", 0))
+		})
+	}
+}
+
+func TestHashGenerator(t *testing.T) {
+	g := NewHashGenerator(6, AlphabetReadable)
+	code := g.Code("https://example.com", 0)
+	assert.Len(t, code, 6)
+	assert.Equal(t, code, g.Code("https://example.com", 0))
+	assert.NotEqual(t, code, g.Code("https://example.com", 1))
+	for _, c := range "0O1lI" {
+		assert.NotContains(t, code, string(c))
+	}
+}
+
+func TestCounterGenerator(t *testing.T) {
+	g := NewCounterGenerator(0, "")
+	assert.Equal(t, "1", g.Code("https://a.com", 0))
+	assert.Equal(t, "2", g.Code("https://a.com", 0))
+
+	g.Seed(60)
+	assert.Equal(t, "z", g.Code("https://a.com", 0))
+	assert.Equal(t, "10", g.Code("https://a.com", 0))
+
+	padded := NewCounterGenerator(4, "")
+	assert.Equal(t, "0001", padded.Code("https://a.com", 0))
+
+	// после рестарта счёт продолжается от самого большого выданного кода,
+	// а не от числа ссылок
+	restarted := NewCounterGenerator(4, "")
+	for _, code := range []string{"0002", "00z0", "0010", "my-alias"} {
+		restarted.Observe(code)
+	}
+	assert.Equal(t, "00z1", restarted.Code("https://a.com", 0))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			code := g.Code("https://a.com", 0)
+			mu.Lock()
+			defer mu.Unlock()
+			assert.False(t, seen[code])
+			seen[code] = true
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRandomGenerator(t *testing.T) {
+	g := NewRandomGenerator(10, AlphabetReadable)
+	code := g.Code("https://a.com", 0)
+	assert.Len(t, code, 10)
+	for _, c := range code {
+		assert.True(t, strings.ContainsRune(AlphabetReadable, c))
+	}
+	assert.NotEqual(t, code, g.Code("https://a.com", 0))
+}
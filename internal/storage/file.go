@@ -0,0 +1,373 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/thalq/url-service/internal/files"
+	logger "github.com/thalq/url-service/internal/middleware"
+	"github.com/thalq/url-service/internal/models"
+)
+
+// FileStorage хранит ссылки в JSON-lines файле. При старте файл целиком
+// читается в индекс в памяти, дальше чтения идут только из индекса,
+// а новые записи дописываются в конец файла. Пока хранилище открыто, оно
+// держит блокировку path+".lock", так что второй процесс, например
+// cmd/compact при работающем сервере, файл не откроет.
+type FileStorage struct {
+	mu sync.Mutex
+	// compactMu не даёт двум сжатиям идти одновременно
+	compactMu sync.Mutex
+	path      string
+	lock      *os.File
+	producer  *files.Producer
+	index     *MemoryStorage
+}
+
+func NewFileStorage(path string) (*FileStorage, error) {
+	lock, err := lockFile(path + ".lock")
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStorage{
+		path:  path,
+		lock:  lock,
+		index: NewMemoryStorage(),
+	}
+	if err := s.load(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	producer, err := files.NewProducer(path)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	s.producer = producer
+	return s, nil
+}
+
+func (s *FileStorage) load() error {
+	Consumer, err := files.NewConsumer(s.path)
+	if err != nil {
+		return err
+	}
+	defer Consumer.Close()
+
+	// tombstone без времени записан до появления восстановления ссылок,
+	// считаем, что удаление произошло при загрузке
+	loadedAt := time.Now().UTC()
+	count := 0
+	for {
+		data, err := Consumer.ReadEvent()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case files.IsTombstone(data):
+			deletedAt := loadedAt
+			if data.DeletedAt != nil {
+				deletedAt = *data.DeletedAt
+			}
+			s.index.markDeleted(data.UserID, []string{data.ShortURL}, deletedAt)
+		case files.IsRestoreRecord(data):
+			s.index.unmarkDeleted(data.UserID, []string{data.ShortURL})
+		case files.IsClickRecord(data):
+			s.index.setClicks(data.ShortURL, data.Clicks)
+		default:
+			s.index.restore(data)
+		}
+		count++
+	}
+	logger.Sugar.Infof("Loaded %d records from %s", count, s.path)
+	return nil
+}
+
+func fileRecord(URLData *models.URLData) *models.URLData {
+	return &models.URLData{
+		CorrelationID: URLData.CorrelationID,
+		OriginalURL:   URLData.OriginalURL,
+		ShortURL:      URLData.ShortURL,
+		UserID:        URLData.UserID,
+		Alias:         URLData.Alias,
+		ExpiresAt:     URLData.ExpiresAt,
+		MaxClicks:     URLData.MaxClicks,
+		PasswordHash:  URLData.PasswordHash,
+		Rules:         URLData.Rules,
+		Variants:      URLData.Variants,
+	}
+}
+
+func (s *FileStorage) SaveURL(ctx context.Context, URLData *models.URLData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.index.canSave(URLData); err != nil {
+		return err
+	}
+	if err := s.producer.WriteEvent(fileRecord(URLData)); err != nil {
+		return err
+	}
+	return s.index.SaveURL(ctx, URLData)
+}
+
+func (s *FileStorage) SaveBatch(ctx context.Context, URLData []*models.URLData) ([]error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	itemErrs := make([]error, len(URLData))
+	for i, data := range URLData {
+		if err := s.index.canSave(data); err != nil {
+			itemErrs[i] = err
+			continue
+		}
+		if err := s.producer.WriteEvent(fileRecord(data)); err != nil {
+			return nil, err
+		}
+		if err := s.index.SaveURL(ctx, data); err != nil {
+			return nil, err
+		}
+	}
+	logger.Sugar.Infoln("Data saved to file")
+	return itemErrs, nil
+}
+
+func (s *FileStorage) GetURL(ctx context.Context, shortURL string) (models.URLData, error) {
+	return s.index.GetURL(ctx, shortURL)
+}
+
+func (s *FileStorage) GetByOriginalURL(ctx context.Context, originalURL string) (models.URLData, error) {
+	return s.index.GetByOriginalURL(ctx, originalURL)
+}
+
+// Visit дописывает в файл новое значение счётчика переходов, чтобы лимит
+// сохранялся между перезапусками.
+func (s *FileStorage) Visit(ctx context.Context, shortURL string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.index.GetURL(ctx, shortURL)
+	if err != nil {
+		return err
+	}
+	if data.Exhausted() {
+		return ErrClicksExhausted
+	}
+	if err := s.producer.WriteEvent(files.NewClickRecord(shortURL, data.Clicks+1)); err != nil {
+		return err
+	}
+	return s.index.Visit(ctx, shortURL)
+}
+
+func (s *FileStorage) GetUserURLs(ctx context.Context, userID string) ([]models.URLData, error) {
+	return s.index.GetUserURLs(ctx, userID)
+}
+
+// UpdateURL дописывает в файл запись со сменой исходного URL.
+func (s *FileStorage) UpdateURL(ctx context.Context, userID, shortURL, originalURL string) (models.URLData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.index.canRetarget(userID, shortURL, originalURL)
+	if err != nil || data.OriginalURL == originalURL {
+		return data, err
+	}
+	record := files.NewUpdateRecord(userID, shortURL, originalURL, time.Now().UTC())
+	if err := s.producer.WriteEvent(record); err != nil {
+		return models.URLData{}, err
+	}
+	return s.index.retarget(userID, shortURL, originalURL, *record.UpdatedAt)
+}
+
+func (s *FileStorage) GetURLHistory(ctx context.Context, shortURL string) ([]models.URLHistory, error) {
+	return s.index.GetURLHistory(ctx, shortURL)
+}
+
+// DeleteURLs дописывает в файл tombstone-записи для ссылок пользователя,
+// чужие ссылки пропускаются, для уже удалённых запись не нужна.
+func (s *FileStorage) DeleteURLs(ctx context.Context, userID string, shortURLs []string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	var owned []string
+	for _, shortURL := range shortURLs {
+		data, err := s.index.GetURL(ctx, shortURL)
+		if err != nil || data.UserID != userID {
+			continue
+		}
+		if !data.DeletedFlag {
+			if err := s.producer.WriteEvent(files.NewTombstone(userID, shortURL, now)); err != nil {
+				// записанные tombstone уже в файле, отметим их и в индексе
+				s.index.markDeleted(userID, owned, now)
+				return nil, err
+			}
+		}
+		owned = append(owned, shortURL)
+	}
+	return s.index.markDeleted(userID, owned, now), nil
+}
+
+// RestoreURLs дописывает в файл записи о восстановлении ссылок.
+func (s *FileStorage) RestoreURLs(ctx context.Context, userID string, shortURLs []string, since time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	restorable := s.index.restorable(userID, shortURLs, since, now)
+	for i, shortURL := range restorable {
+		if err := s.producer.WriteEvent(files.NewRestoreRecord(userID, shortURL, now)); err != nil {
+			s.index.unmarkDeleted(userID, restorable[:i])
+			return restorable[:i], err
+		}
+	}
+	s.index.unmarkDeleted(userID, restorable)
+	return restorable, nil
+}
+
+// PurgeDeleted удаляет ссылки из индекса и сразу сжимает файл, чтобы
+// их записи исчезли и с диска.
+func (s *FileStorage) PurgeDeleted(ctx context.Context, before time.Time) ([]string, error) {
+	s.mu.Lock()
+	purged, err := s.index.PurgeDeleted(ctx, before)
+	s.mu.Unlock()
+	if err != nil || len(purged) == 0 {
+		return purged, err
+	}
+	return purged, s.Compact()
+}
+
+// ExpireURLs дописывает tombstone-записи для ссылок с истёкшим сроком
+// от имени их владельцев.
+func (s *FileStorage) ExpireURLs(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expired := s.index.expired(now)
+	for i, data := range expired {
+		if err := s.producer.WriteEvent(files.NewTombstone(data.UserID, data.ShortURL, now)); err != nil {
+			return i, err
+		}
+		s.index.markDeleted(data.UserID, []string{data.ShortURL}, now)
+	}
+	return len(expired), nil
+}
+
+func (s *FileStorage) ScanShortURLs(ctx context.Context, fn func(shortURL string) error) error {
+	return s.index.ScanShortURLs(ctx, fn)
+}
+
+func (s *FileStorage) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Compact переписывает файл по текущему индексу: повторы схлопываются,
+// а ссылка с tombstone превращается в одну запись с is_deleted.
+// Новый файл пишется рядом во временный и атомарно подменяет старый.
+// Файл пишется из снимка индекса без блокировки хранилища, так что редиректы
+// и записи во время сжатия не ждут. Блокировка берётся снова только чтобы
+// дописать появившиеся за это время записи и подменить файл.
+func (s *FileStorage) Compact() error {
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	// producer сбрасывает каждую запись сразу, поэтому размер файла под
+	// блокировкой — ровно то, что уже есть в снимке
+	s.mu.Lock()
+	records := s.index.snapshot()
+	info, err := os.Stat(s.path)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".compact-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+	if err := tmp.Chmod(info.Mode()); err != nil {
+		tmp.Close()
+		return err
+	}
+	tmp.Close()
+
+	producer, err := files.NewProducer(tmpPath)
+	if err != nil {
+		return err
+	}
+	if err := producer.WriteEvents(records); err != nil {
+		producer.Close()
+		return err
+	}
+	afterSnapshot()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.appendTail(producer, info.Size()); err != nil {
+		producer.Close()
+		return err
+	}
+	if err := producer.Sync(); err != nil {
+		producer.Close()
+		return err
+	}
+	// producer открыт на дозапись и после переименования пишет уже в новый
+	// файл, поэтому переоткрывать его не нужно: если переименование не
+	// удалось, хранилище продолжает писать в старый файл
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		producer.Close()
+		return err
+	}
+	s.producer.Close()
+	s.producer = producer
+	logger.Sugar.Infof("Compacted %s: %d records", s.path, len(records))
+	return nil
+}
+
+// afterSnapshot вызывается, когда снимок записан, а блокировка ещё не
+// взята: тесты дописывают в нём ссылки во время сжатия.
+var afterSnapshot = func() {}
+
+// appendTail переносит в producer записи, дописанные в файл после offset.
+func (s *FileStorage) appendTail(producer *files.Producer, offset int64) error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	return producer.Append(file)
+}
+
+// StartCompaction запускает периодическое сжатие файла.
+func (s *FileStorage) StartCompaction(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := s.Compact(); err != nil {
+				logger.Sugar.Errorf("Failed to compact file storage: %v", err)
+			}
+		}
+	}()
+}
+
+func (s *FileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.producer.Close()
+	s.lock.Close()
+	return err
+}
@@ -0,0 +1,415 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thalq/url-service/internal/files"
+	logger "github.com/thalq/url-service/internal/middleware"
+	"github.com/thalq/url-service/internal/models"
+)
+
+func TestFileStorage(t *testing.T) {
+	logger.InitLogger()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test_data.log")
+	store, err := NewFileStorage(path)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	urlData := &models.URLData{
+		OriginalURL:   "http://example.com",
+		ShortURL:      "exmpl",
+		CorrelationID: "12345",
+		UserID:        "user1",
+	}
+
+	t.Run("save and get", func(t *testing.T) {
+		assert.NoError(t, store.SaveURL(ctx, urlData))
+
+		got, err := store.GetURL(ctx, "exmpl")
+		assert.NoError(t, err)
+		assert.Equal(t, *urlData, got)
+	})
+
+	t.Run("conflict on same original url", func(t *testing.T) {
+		err := store.SaveURL(ctx, &models.URLData{OriginalURL: "http://example.com", ShortURL: "other"})
+		assert.ErrorIs(t, err, ErrConflict)
+	})
+
+	t.Run("batch skips existing", func(t *testing.T) {
+		itemErrs, err := store.SaveBatch(ctx, []*models.URLData{
+			{OriginalURL: "http://example.org", ShortURL: "exmpl2", UserID: "user1"},
+			{OriginalURL: "http://example.com", ShortURL: "exmpl", UserID: "user1"},
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, itemErrs[0])
+		assert.ErrorIs(t, itemErrs[1], ErrConflict)
+
+		_, err = store.GetURL(ctx, "exmpl2")
+		assert.NoError(t, err)
+	})
+
+	t.Run("get by user", func(t *testing.T) {
+		urls, err := store.GetUserURLs(ctx, "user1")
+		assert.NoError(t, err)
+		assert.Len(t, urls, 2)
+
+		urls, err = store.GetUserURLs(ctx, "user2")
+		assert.NoError(t, err)
+		assert.Empty(t, urls)
+	})
+
+	t.Run("reload from file", func(t *testing.T) {
+		assert.NoError(t, store.Close())
+		reloaded, err := NewFileStorage(path)
+		assert.NoError(t, err)
+		defer reloaded.Close()
+
+		got, err := reloaded.GetURL(ctx, "exmpl")
+		assert.NoError(t, err)
+		assert.Equal(t, *urlData, got)
+
+		urls, err := reloaded.GetUserURLs(ctx, "user1")
+		assert.NoError(t, err)
+		assert.Len(t, urls, 2)
+	})
+}
+
+func TestFileStorage_Delete(t *testing.T) {
+	logger.InitLogger()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test_data.log")
+	store, err := NewFileStorage(path)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	_, err = store.SaveBatch(ctx, []*models.URLData{
+		{OriginalURL: "http://example.com", ShortURL: "exmpl1", UserID: "user1"},
+		{OriginalURL: "http://example.org", ShortURL: "exmpl2", UserID: "user2"},
+	})
+	assert.NoError(t, err)
+
+	deleted, err := store.DeleteURLs(ctx, "user1", []string{"exmpl1", "exmpl2", "missing"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"exmpl1"}, deleted)
+
+	check := func(store *FileStorage) {
+		got, err := store.GetURL(ctx, "exmpl1")
+		assert.NoError(t, err)
+		assert.True(t, got.DeletedFlag)
+		assert.Equal(t, "http://example.com", got.OriginalURL)
+
+		got, err = store.GetURL(ctx, "exmpl2")
+		assert.NoError(t, err)
+		assert.False(t, got.DeletedFlag)
+	}
+	check(store)
+
+	assert.NoError(t, store.Close())
+	reloaded, err := NewFileStorage(path)
+	assert.NoError(t, err)
+	defer reloaded.Close()
+	check(reloaded)
+
+	consumer, err := files.NewConsumer(path)
+	assert.NoError(t, err)
+	defer consumer.Close()
+	var tombstones int
+	for {
+		data, err := consumer.ReadEvent()
+		if err != nil {
+			break
+		}
+		if files.IsTombstone(data) {
+			tombstones++
+			assert.Equal(t, "user1", data.UserID)
+		}
+	}
+	assert.Equal(t, 1, tombstones)
+}
+
+func TestFileStorage_ExpireURLs(t *testing.T) {
+	logger.InitLogger()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test_data.log")
+	store, err := NewFileStorage(path)
+	assert.NoError(t, err)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+	assert.NoError(t, store.SaveURL(ctx, &models.URLData{OriginalURL: "http://expired.com", ShortURL: "expired", UserID: "user1", ExpiresAt: &past}))
+	assert.NoError(t, store.SaveURL(ctx, &models.URLData{OriginalURL: "http://alive.com", ShortURL: "alive", UserID: "user2", ExpiresAt: &future}))
+	assert.NoError(t, store.SaveURL(ctx, &models.URLData{OriginalURL: "http://forever.com", ShortURL: "forever", UserID: "user1"}))
+
+	count, err := store.ExpireURLs(ctx, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	count, err = store.ExpireURLs(ctx, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+	assert.NoError(t, store.Close())
+
+	reloaded, err := NewFileStorage(path)
+	assert.NoError(t, err)
+	defer reloaded.Close()
+	got, err := reloaded.GetURL(ctx, "expired")
+	assert.NoError(t, err)
+	assert.True(t, got.DeletedFlag)
+	got, err = reloaded.GetURL(ctx, "alive")
+	assert.NoError(t, err)
+	assert.False(t, got.DeletedFlag)
+	assert.True(t, future.Equal(*got.ExpiresAt))
+}
+
+func TestFileStorage_Visit(t *testing.T) {
+	logger.InitLogger()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test_data.log")
+	store, err := NewFileStorage(path)
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.SaveURL(ctx, &models.URLData{OriginalURL: "http://invite.com", ShortURL: "invite", MaxClicks: 2}))
+	assert.NoError(t, store.Visit(ctx, "invite"))
+	assert.NoError(t, store.Close())
+
+	reloaded, err := NewFileStorage(path)
+	assert.NoError(t, err)
+	defer reloaded.Close()
+	got, err := reloaded.GetURL(ctx, "invite")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, got.Clicks)
+	assert.NoError(t, reloaded.Visit(ctx, "invite"))
+	assert.ErrorIs(t, reloaded.Visit(ctx, "invite"), ErrClicksExhausted)
+
+	assert.NoError(t, reloaded.Compact())
+	got, err = reloaded.GetURL(ctx, "invite")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, got.Clicks)
+}
+
+func TestFileStorage_UpdateURL(t *testing.T) {
+	logger.InitLogger()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test_data.log")
+	store, err := NewFileStorage(path)
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.SaveURL(ctx, &models.URLData{OriginalURL: "http://v1.com", ShortURL: "code", UserID: "user1"}))
+	_, err = store.UpdateURL(ctx, "user1", "code", "http://v2.com")
+	assert.NoError(t, err)
+	_, err = store.UpdateURL(ctx, "user1", "code", "http://v3.com")
+	assert.NoError(t, err)
+	// старый адрес освободился и может быть сокращён заново
+	assert.NoError(t, store.SaveURL(ctx, &models.URLData{OriginalURL: "http://v1.com", ShortURL: "other", UserID: "user2"}))
+	assert.NoError(t, store.Close())
+
+	check := func(store *FileStorage) {
+		got, err := store.GetURL(ctx, "code")
+		assert.NoError(t, err)
+		assert.Equal(t, "http://v3.com", got.OriginalURL)
+		history, err := store.GetURLHistory(ctx, "code")
+		assert.NoError(t, err)
+		assert.Len(t, history, 2)
+		assert.Equal(t, "http://v1.com", history[0].OriginalURL)
+		assert.Equal(t, "http://v2.com", history[1].OriginalURL)
+		got, err = store.GetURL(ctx, "other")
+		assert.NoError(t, err)
+		assert.Equal(t, "http://v1.com", got.OriginalURL)
+	}
+
+	reloaded, err := NewFileStorage(path)
+	assert.NoError(t, err)
+	check(reloaded)
+	assert.NoError(t, reloaded.Compact())
+	assert.NoError(t, reloaded.Close())
+
+	compacted, err := NewFileStorage(path)
+	assert.NoError(t, err)
+	defer compacted.Close()
+	check(compacted)
+}
+
+func TestFileStorage_RestoreAndPurge(t *testing.T) {
+	logger.InitLogger()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test_data.log")
+	store, err := NewFileStorage(path)
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.SaveURL(ctx, &models.URLData{OriginalURL: "http://a.com", ShortURL: "a", UserID: "user1"}))
+	assert.NoError(t, store.SaveURL(ctx, &models.URLData{OriginalURL: "http://b.com", ShortURL: "b", UserID: "user1"}))
+	_, err = store.DeleteURLs(ctx, "user1", []string{"a", "b"})
+	assert.NoError(t, err)
+	restored, err := store.RestoreURLs(ctx, "user1", []string{"a"}, time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, restored)
+	assert.NoError(t, store.Close())
+
+	reloaded, err := NewFileStorage(path)
+	assert.NoError(t, err)
+	got, err := reloaded.GetURL(ctx, "a")
+	assert.NoError(t, err)
+	assert.False(t, got.DeletedFlag)
+	got, err = reloaded.GetURL(ctx, "b")
+	assert.NoError(t, err)
+	assert.True(t, got.DeletedFlag)
+
+	purgedCodes, err := reloaded.PurgeDeleted(ctx, time.Now().Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b"}, purgedCodes)
+	assert.NoError(t, reloaded.Close())
+
+	purged, err := NewFileStorage(path)
+	assert.NoError(t, err)
+	defer purged.Close()
+	_, err = purged.GetURL(ctx, "b")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = purged.GetURL(ctx, "a")
+	assert.NoError(t, err)
+}
+
+func TestFileStorage_LoadDuplicates(t *testing.T) {
+	logger.InitLogger()
+	path := filepath.Join(t.TempDir(), "test_data.log")
+	producer, err := files.NewProducer(path)
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		err = producer.WriteEvent(&models.URLData{OriginalURL: "https://yandex.ru/", ShortURL: "NzdmY2E1", UserID: "user1"})
+		assert.NoError(t, err)
+	}
+	producer.Close()
+
+	store, err := NewFileStorage(path)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	urls, err := store.GetUserURLs(context.Background(), "user1")
+	assert.NoError(t, err)
+	assert.Len(t, urls, 1)
+}
+
+func TestFileStorage_Lock(t *testing.T) {
+	logger.InitLogger()
+	path := filepath.Join(t.TempDir(), "test_data.log")
+	store, err := NewFileStorage(path)
+	assert.NoError(t, err)
+
+	// второй процесс, например cmd/compact, файл работающего сервера не откроет
+	_, err = NewFileStorage(path)
+	assert.ErrorIs(t, err, ErrStorageLocked)
+
+	assert.NoError(t, store.Close())
+	reopened, err := NewFileStorage(path)
+	assert.NoError(t, err)
+	assert.NoError(t, reopened.Close())
+}
+
+func TestFileStorage_Compact(t *testing.T) {
+	logger.InitLogger()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test_data.log")
+	producer, err := files.NewProducer(path)
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		err = producer.WriteEvent(&models.URLData{OriginalURL: "https://yandex.ru/", ShortURL: "NzdmY2E1", UserID: "user1"})
+		assert.NoError(t, err)
+	}
+	producer.Close()
+
+	store, err := NewFileStorage(path)
+	assert.NoError(t, err)
+	defer store.Close()
+	assert.NoError(t, store.SaveURL(ctx, &models.URLData{OriginalURL: "http://example.com", ShortURL: "exmpl", UserID: "user1"}))
+	_, err = store.DeleteURLs(ctx, "user1", []string{"exmpl"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.Compact())
+	assert.NoError(t, store.SaveURL(ctx, &models.URLData{OriginalURL: "http://example.org", ShortURL: "exmpl2", UserID: "user2"}))
+
+	consumer, err := files.NewConsumer(path)
+	assert.NoError(t, err)
+	defer consumer.Close()
+	var lines []*models.URLData
+	for {
+		data, err := consumer.ReadEvent()
+		if err != nil {
+			break
+		}
+		lines = append(lines, data)
+	}
+	assert.Len(t, lines, 3)
+
+	assert.NoError(t, store.Close())
+	reloaded, err := NewFileStorage(path)
+	assert.NoError(t, err)
+	defer reloaded.Close()
+
+	got, err := reloaded.GetURL(ctx, "exmpl")
+	assert.NoError(t, err)
+	assert.True(t, got.DeletedFlag)
+
+	got, err = reloaded.GetURL(ctx, "exmpl2")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://example.org", got.OriginalURL)
+}
+
+func TestFileStorage_CompactKeepsConcurrentWrites(t *testing.T) {
+	logger.InitLogger()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test_data.log")
+	store, err := NewFileStorage(path)
+	assert.NoError(t, err)
+	assert.NoError(t, store.SaveURL(ctx, &models.URLData{OriginalURL: "http://old.com", ShortURL: "old", UserID: "user1"}))
+
+	// запись, сделанная во время сжатия, должна попасть в новый файл
+	defer func() { afterSnapshot = func() {} }()
+	afterSnapshot = func() {
+		assert.NoError(t, store.SaveURL(ctx, &models.URLData{OriginalURL: "http://new.com", ShortURL: "new", UserID: "user1"}))
+		_, err := store.DeleteURLs(ctx, "user1", []string{"old"})
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, store.Compact())
+	assert.NoError(t, store.Close())
+
+	reloaded, err := NewFileStorage(path)
+	assert.NoError(t, err)
+	defer reloaded.Close()
+	got, err := reloaded.GetURL(ctx, "new")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://new.com", got.OriginalURL)
+	got, err = reloaded.GetURL(ctx, "old")
+	assert.NoError(t, err)
+	assert.True(t, got.DeletedFlag)
+}
+
+func BenchmarkFileStorage_GetURL(b *testing.B) {
+	logger.InitLogger()
+	ctx := context.Background()
+	store, err := NewFileStorage(filepath.Join(b.TempDir(), "test_data.log"))
+	if err != nil {
+		b.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	for i := 0; i < 10000; i++ {
+		err := store.SaveURL(ctx, &models.URLData{
+			OriginalURL: fmt.Sprintf("http://example.com/%d", i),
+			ShortURL:    fmt.Sprintf("short%d", i),
+			UserID:      "user1",
+		})
+		if err != nil {
+			b.Fatalf("Failed to save URL: %v", err)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := store.GetURL(ctx, "short0"); err != nil {
+			b.Fatalf("Failed to get URL: %v", err)
+		}
+	}
+}
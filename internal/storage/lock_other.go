@@ -0,0 +1,11 @@
+//go:build !unix
+
+package storage
+
+import "os"
+
+// lockFile без flock только создаёт файл блокировки: на этих системах
+// защиты от второго процесса нет.
+func lockFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+}
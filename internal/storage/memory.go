@@ -0,0 +1,408 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/thalq/url-service/internal/models"
+)
+
+// MemoryStorage хранит ссылки только в памяти процесса, без диска и базы.
+// Используется, когда не заданы ни DATABASE_DSN, ни FILE_STORAGE_PATH.
+type MemoryStorage struct {
+	mu         sync.RWMutex
+	byShort    map[string]*models.URLData
+	byOriginal map[string]string
+	byUser     map[string][]string
+	order      []string
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		byShort:    make(map[string]*models.URLData),
+		byOriginal: make(map[string]string),
+		byUser:     make(map[string][]string),
+	}
+}
+
+func (s *MemoryStorage) put(URLData *models.URLData) {
+	data := *URLData
+	s.byShort[data.ShortURL] = &data
+	if data.Alias != "" {
+		s.byShort[data.Alias] = &data
+	}
+	s.byOriginal[data.OriginalURL] = data.ShortURL
+	s.byUser[data.UserID] = append(s.byUser[data.UserID], data.ShortURL)
+	s.order = append(s.order, data.ShortURL)
+}
+
+// snapshot возвращает копии всех записей в порядке добавления.
+func (s *MemoryStorage) snapshot() []models.URLData {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	URLData := make([]models.URLData, 0, len(s.order))
+	for _, shortURL := range s.order {
+		URLData = append(URLData, *s.byShort[shortURL])
+	}
+	return URLData
+}
+
+// restore добавляет запись, прочитанную из файла. Повторы одной и той же
+// ссылки в файле пропускаются, побеждает первая запись. Запись с updated_at
+// для уже загруженного кода — это смена исходного URL.
+func (s *MemoryStorage) restore(URLData *models.URLData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.byShort[URLData.ShortURL]; ok && URLData.UpdatedAt != nil {
+		s.move(URLData.UserID, URLData.ShortURL, URLData.OriginalURL, *URLData.UpdatedAt)
+		return
+	}
+	if s.check(URLData) != nil {
+		return
+	}
+	s.put(URLData)
+}
+
+// check возвращает ErrConflict или ErrShortURLTaken, если ссылку сохранить нельзя.
+// Вызывается под блокировкой.
+func (s *MemoryStorage) check(URLData *models.URLData) error {
+	if _, ok := s.byOriginal[URLData.OriginalURL]; ok {
+		return ErrConflict
+	}
+	if _, ok := s.byShort[URLData.ShortURL]; ok {
+		return ErrShortURLTaken
+	}
+	if URLData.Alias != "" {
+		if _, ok := s.byShort[URLData.Alias]; ok {
+			return ErrAliasTaken
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) canSave(URLData *models.URLData) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.check(URLData)
+}
+
+func (s *MemoryStorage) SaveURL(ctx context.Context, URLData *models.URLData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(URLData); err != nil {
+		return err
+	}
+	s.put(URLData)
+	return nil
+}
+
+func (s *MemoryStorage) SaveBatch(ctx context.Context, URLData []*models.URLData) ([]error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	itemErrs := make([]error, len(URLData))
+	for i, data := range URLData {
+		if err := s.check(data); err != nil {
+			itemErrs[i] = err
+			continue
+		}
+		s.put(data)
+	}
+	return itemErrs, nil
+}
+
+func (s *MemoryStorage) GetURL(ctx context.Context, shortURL string) (models.URLData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.byShort[shortURL]
+	if !ok {
+		return models.URLData{}, ErrNotFound
+	}
+	return *data, nil
+}
+
+func (s *MemoryStorage) GetByOriginalURL(ctx context.Context, originalURL string) (models.URLData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	shortURL, ok := s.byOriginal[originalURL]
+	if !ok {
+		return models.URLData{}, ErrNotFound
+	}
+	return *s.byShort[shortURL], nil
+}
+
+func (s *MemoryStorage) Visit(ctx context.Context, shortURL string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.byShort[shortURL]
+	if !ok {
+		return ErrNotFound
+	}
+	if data.Exhausted() {
+		return ErrClicksExhausted
+	}
+	data.Clicks++
+	return nil
+}
+
+// setClicks восстанавливает счётчик переходов, прочитанный из файла.
+func (s *MemoryStorage) setClicks(shortURL string, clicks int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if data, ok := s.byShort[shortURL]; ok {
+		data.Clicks = clicks
+	}
+}
+
+func (s *MemoryStorage) GetUserURLs(ctx context.Context, userID string) ([]models.URLData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var URLData []models.URLData
+	for _, shortURL := range s.byUser[userID] {
+		URLData = append(URLData, *s.byShort[shortURL])
+	}
+	return URLData, nil
+}
+
+// checkRetarget возвращает ошибку UpdateURL, если ссылку перенаправить нельзя.
+// Вызывается под блокировкой.
+func (s *MemoryStorage) checkRetarget(userID, shortURL, originalURL string) (*models.URLData, error) {
+	data, ok := s.byShort[shortURL]
+	if !ok || data.UserID != userID || data.DeletedFlag {
+		return nil, ErrNotFound
+	}
+	if data.OriginalURL == originalURL {
+		return data, nil
+	}
+	if _, ok := s.byOriginal[originalURL]; ok {
+		return nil, ErrConflict
+	}
+	return data, nil
+}
+
+func (s *MemoryStorage) canRetarget(userID, shortURL, originalURL string) (models.URLData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, err := s.checkRetarget(userID, shortURL, originalURL)
+	if err != nil {
+		return models.URLData{}, err
+	}
+	return *data, nil
+}
+
+// move меняет исходный URL ссылки. Вызывается под блокировкой.
+func (s *MemoryStorage) move(userID, shortURL, originalURL string, at time.Time) (models.URLData, error) {
+	data, err := s.checkRetarget(userID, shortURL, originalURL)
+	if err != nil {
+		return models.URLData{}, err
+	}
+	if data.OriginalURL == originalURL {
+		return *data, nil
+	}
+	delete(s.byOriginal, data.OriginalURL)
+	s.byOriginal[originalURL] = data.ShortURL
+	// копия, чтобы не писать в массив, который видят выданные раньше копии
+	history := make([]models.URLHistory, len(data.History), len(data.History)+1)
+	copy(history, data.History)
+	data.History = append(history, models.URLHistory{OriginalURL: data.OriginalURL, ChangedAt: at})
+	data.OriginalURL = originalURL
+	data.UpdatedAt = &at
+	return *data, nil
+}
+
+func (s *MemoryStorage) retarget(userID, shortURL, originalURL string, at time.Time) (models.URLData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.move(userID, shortURL, originalURL, at)
+}
+
+func (s *MemoryStorage) UpdateURL(ctx context.Context, userID, shortURL, originalURL string) (models.URLData, error) {
+	return s.retarget(userID, shortURL, originalURL, time.Now().UTC())
+}
+
+func (s *MemoryStorage) GetURLHistory(ctx context.Context, shortURL string) ([]models.URLHistory, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.byShort[shortURL]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]models.URLHistory(nil), data.History...), nil
+}
+
+func (s *MemoryStorage) DeleteURLs(ctx context.Context, userID string, shortURLs []string) ([]string, error) {
+	return s.markDeleted(userID, shortURLs, time.Now().UTC()), nil
+}
+
+// markDeleted помечает ссылки пользователя удалёнными в момент at и
+// возвращает коды пользователя из shortURLs. Уже удалённые ссылки сохраняют
+// прежний момент удаления.
+func (s *MemoryStorage) markDeleted(userID string, shortURLs []string, at time.Time) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var owned []string
+	for _, shortURL := range shortURLs {
+		data, ok := s.byShort[shortURL]
+		if !ok || data.UserID != userID {
+			continue
+		}
+		if !data.DeletedFlag {
+			data.DeletedFlag = true
+			data.DeletedAt = &at
+		}
+		owned = append(owned, shortURL)
+	}
+	return owned
+}
+
+// findRestorable возвращает коды ссылок, которые RestoreURLs может
+// восстановить. Вызывается под блокировкой.
+func (s *MemoryStorage) findRestorable(userID string, shortURLs []string, since, now time.Time) []string {
+	var restorable []string
+	for _, shortURL := range shortURLs {
+		data, ok := s.byShort[shortURL]
+		if !ok || data.UserID != userID || !data.DeletedFlag || data.Expired(now) {
+			continue
+		}
+		if data.DeletedAt == nil || data.DeletedAt.Before(since) {
+			continue
+		}
+		restorable = append(restorable, data.ShortURL)
+	}
+	return restorable
+}
+
+func (s *MemoryStorage) restorable(userID string, shortURLs []string, since, now time.Time) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.findRestorable(userID, shortURLs, since, now)
+}
+
+// unmarkDeleted снимает пометку удаления со ссылок пользователя.
+func (s *MemoryStorage) unmarkDeleted(userID string, shortURLs []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, shortURL := range shortURLs {
+		if data, ok := s.byShort[shortURL]; ok && data.UserID == userID {
+			data.DeletedFlag = false
+			data.DeletedAt = nil
+		}
+	}
+}
+
+func (s *MemoryStorage) RestoreURLs(ctx context.Context, userID string, shortURLs []string, since time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	restored := s.findRestorable(userID, shortURLs, since, time.Now())
+	for _, shortURL := range restored {
+		data := s.byShort[shortURL]
+		data.DeletedFlag = false
+		data.DeletedAt = nil
+	}
+	return restored, nil
+}
+
+func (s *MemoryStorage) PurgeDeleted(ctx context.Context, before time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := make(map[string]bool)
+	var codes []string
+	order := s.order[:0]
+	for _, shortURL := range s.order {
+		data := s.byShort[shortURL]
+		if !data.DeletedFlag || data.DeletedAt == nil || !data.DeletedAt.Before(before) {
+			order = append(order, shortURL)
+			continue
+		}
+		delete(s.byShort, shortURL)
+		if data.Alias != "" {
+			delete(s.byShort, data.Alias)
+		}
+		// original_url мог освободиться и достаться другой ссылке
+		if s.byOriginal[data.OriginalURL] == shortURL {
+			delete(s.byOriginal, data.OriginalURL)
+		}
+		purged[shortURL] = true
+		codes = append(codes, shortURL)
+	}
+	s.order = order
+	if len(purged) == 0 {
+		return nil, nil
+	}
+	for userID, shortURLs := range s.byUser {
+		kept := shortURLs[:0]
+		for _, shortURL := range shortURLs {
+			if !purged[shortURL] {
+				kept = append(kept, shortURL)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.byUser, userID)
+		} else {
+			s.byUser[userID] = kept
+		}
+	}
+	return codes, nil
+}
+
+// expired возвращает копии ещё не удалённых ссылок, срок которых истёк к now.
+func (s *MemoryStorage) expired(now time.Time) []models.URLData {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var URLData []models.URLData
+	for _, shortURL := range s.order {
+		data := s.byShort[shortURL]
+		if !data.DeletedFlag && data.Expired(now) {
+			URLData = append(URLData, *data)
+		}
+	}
+	return URLData
+}
+
+func (s *MemoryStorage) ExpireURLs(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, shortURL := range s.order {
+		data := s.byShort[shortURL]
+		if !data.DeletedFlag && data.Expired(now) {
+			data.DeletedFlag = true
+			data.DeletedAt = &now
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *MemoryStorage) ScanShortURLs(ctx context.Context, fn func(shortURL string) error) error {
+	s.mu.RLock()
+	codes := append([]string(nil), s.order...)
+	s.mu.RUnlock()
+	for _, shortURL := range codes {
+		if err := fn(shortURL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) Ping(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thalq/url-service/internal/models"
+	"github.com/thalq/url-service/internal/shortener"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStorage()
+
+	urlData := &models.URLData{
+		OriginalURL:   "http://example.com",
+		ShortURL:      "exmpl",
+		CorrelationID: "12345",
+		UserID:        "user1",
+	}
+
+	t.Run("save and get", func(t *testing.T) {
+		assert.NoError(t, store.SaveURL(ctx, urlData))
+
+		got, err := store.GetURL(ctx, "exmpl")
+		assert.NoError(t, err)
+		assert.Equal(t, *urlData, got)
+
+		_, err = store.GetURL(ctx, "missing")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("conflict on same original url", func(t *testing.T) {
+		err := store.SaveURL(ctx, &models.URLData{OriginalURL: "http://example.com", ShortURL: "other"})
+		assert.ErrorIs(t, err, ErrConflict)
+	})
+
+	t.Run("short url taken by another url", func(t *testing.T) {
+		err := store.SaveURL(ctx, &models.URLData{OriginalURL: "http://other.com", ShortURL: "exmpl"})
+		assert.ErrorIs(t, err, ErrShortURLTaken)
+		assert.ErrorIs(t, err, shortener.ErrCollision)
+
+		got, err := store.GetURL(ctx, "exmpl")
+		assert.NoError(t, err)
+		assert.Equal(t, "http://example.com", got.OriginalURL)
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		itemErrs, err := store.SaveBatch(ctx, []*models.URLData{
+			{OriginalURL: "http://example.org", ShortURL: "exmpl2", UserID: "user1"},
+			{OriginalURL: "http://example.org", ShortURL: "exmpl2", UserID: "user1"},
+			{OriginalURL: "http://example.com", ShortURL: "exmpl", UserID: "user1"},
+			{OriginalURL: "http://example.net", ShortURL: "exmpl3", UserID: "user2"},
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, itemErrs[0])
+		assert.ErrorIs(t, itemErrs[1], ErrConflict)
+		assert.ErrorIs(t, itemErrs[2], ErrConflict)
+		assert.NoError(t, itemErrs[3])
+
+		urls, err := store.GetUserURLs(ctx, "user1")
+		assert.NoError(t, err)
+		assert.Len(t, urls, 2)
+	})
+
+	t.Run("alias", func(t *testing.T) {
+		err := store.SaveURL(ctx, &models.URLData{OriginalURL: "http://alias.com", ShortURL: "alias1", Alias: "spring-sale"})
+		assert.NoError(t, err)
+
+		got, err := store.GetURL(ctx, "spring-sale")
+		assert.NoError(t, err)
+		assert.Equal(t, "http://alias.com", got.OriginalURL)
+
+		err = store.SaveURL(ctx, &models.URLData{OriginalURL: "http://alias.org", ShortURL: "alias2", Alias: "spring-sale"})
+		assert.ErrorIs(t, err, ErrAliasTaken)
+		err = store.SaveURL(ctx, &models.URLData{OriginalURL: "http://alias.org", ShortURL: "alias2", Alias: "exmpl"})
+		assert.ErrorIs(t, err, ErrAliasTaken)
+	})
+
+	t.Run("delete only own urls", func(t *testing.T) {
+		deleted, err := store.DeleteURLs(ctx, "user1", []string{"exmpl", "exmpl3"})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"exmpl"}, deleted)
+
+		got, err := store.GetURL(ctx, "exmpl")
+		assert.NoError(t, err)
+		assert.True(t, got.DeletedFlag)
+
+		got, err = store.GetURL(ctx, "exmpl3")
+		assert.NoError(t, err)
+		assert.False(t, got.DeletedFlag)
+	})
+}
+
+func TestMemoryStorage_ExpireURLs(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStorage()
+
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+	assert.NoError(t, store.SaveURL(ctx, &models.URLData{OriginalURL: "http://expired.com", ShortURL: "expired", Alias: "old-sale", ExpiresAt: &past}))
+	assert.NoError(t, store.SaveURL(ctx, &models.URLData{OriginalURL: "http://alive.com", ShortURL: "alive", ExpiresAt: &future}))
+
+	count, err := store.ExpireURLs(ctx, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	got, err := store.GetURL(ctx, "old-sale")
+	assert.NoError(t, err)
+	assert.True(t, got.DeletedFlag)
+	got, err = store.GetURL(ctx, "alive")
+	assert.NoError(t, err)
+	assert.False(t, got.DeletedFlag)
+}
+
+func TestMemoryStorage_VisitConcurrent(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStorage()
+	assert.NoError(t, store.SaveURL(ctx, &models.URLData{OriginalURL: "http://invite.com", ShortURL: "invite", MaxClicks: 5}))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	visited := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := store.Visit(ctx, "invite")
+			if err == nil {
+				mu.Lock()
+				visited++
+				mu.Unlock()
+				return
+			}
+			assert.ErrorIs(t, err, ErrClicksExhausted)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 5, visited)
+
+	got, err := store.GetURL(ctx, "invite")
+	assert.NoError(t, err)
+	assert.True(t, got.Exhausted())
+}
+
+func TestMemoryStorage_UpdateURL(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStorage()
+	assert.NoError(t, store.SaveURL(ctx, &models.URLData{OriginalURL: "http://old.com", ShortURL: "code", UserID: "user1", Alias: "landing"}))
+	assert.NoError(t, store.SaveURL(ctx, &models.URLData{OriginalURL: "http://taken.com", ShortURL: "taken", UserID: "user1"}))
+
+	_, err := store.UpdateURL(ctx, "user2", "code", "http://new.com")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = store.UpdateURL(ctx, "user1", "code", "http://taken.com")
+	assert.ErrorIs(t, err, ErrConflict)
+
+	updated, err := store.UpdateURL(ctx, "user1", "code", "http://new.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://new.com", updated.OriginalURL)
+	assert.NotNil(t, updated.UpdatedAt)
+
+	got, err := store.GetURL(ctx, "landing")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://new.com", got.OriginalURL)
+	got, err = store.GetByOriginalURL(ctx, "http://new.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "code", got.ShortURL)
+	_, err = store.GetByOriginalURL(ctx, "http://old.com")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	history, err := store.GetURLHistory(ctx, "code")
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+	assert.Equal(t, "http://old.com", history[0].OriginalURL)
+}
+
+func TestMemoryStorage_RestoreAndPurge(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStorage()
+	for _, code := range []string{"a", "b", "c"} {
+		err := store.SaveURL(ctx, &models.URLData{OriginalURL: "http://" + code + ".com", ShortURL: code, UserID: "user1"})
+		assert.NoError(t, err)
+	}
+	_, err := store.DeleteURLs(ctx, "user1", []string{"a", "b", "c"})
+	assert.NoError(t, err)
+
+	restored, err := store.RestoreURLs(ctx, "user2", []string{"a"}, time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Empty(t, restored)
+	restored, err = store.RestoreURLs(ctx, "user1", []string{"a"}, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Empty(t, restored, "deleted before the grace period")
+
+	restored, err = store.RestoreURLs(ctx, "user1", []string{"a", "missing"}, time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, restored)
+	got, err := store.GetURL(ctx, "a")
+	assert.NoError(t, err)
+	assert.False(t, got.DeletedFlag)
+	assert.Nil(t, got.DeletedAt)
+
+	purged, err := store.PurgeDeleted(ctx, time.Now().Add(time.Second))
+	assert.NoError(t, err)
+	assert.Len(t, purged, 2)
+	assert.Contains(t, purged, "b")
+	_, err = store.GetURL(ctx, "b")
+	assert.ErrorIs(t, err, ErrNotFound)
+	urls, err := store.GetUserURLs(ctx, "user1")
+	assert.NoError(t, err)
+	assert.Len(t, urls, 1)
+	// освободившийся адрес можно сократить снова
+	assert.NoError(t, store.SaveURL(ctx, &models.URLData{OriginalURL: "http://b.com", ShortURL: "b2"}))
+}
+
+func TestMemoryStorage_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStorage()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			shortURL := fmt.Sprintf("short%d", i)
+			err := store.SaveURL(ctx, &models.URLData{
+				OriginalURL: fmt.Sprintf("http://example.com/%d", i),
+				ShortURL:    shortURL,
+				UserID:      "user1",
+			})
+			assert.NoError(t, err)
+			_, err = store.GetURL(ctx, shortURL)
+			assert.NoError(t, err)
+			_, err = store.DeleteURLs(ctx, "user1", []string{shortURL})
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	urls, err := store.GetUserURLs(ctx, "user1")
+	assert.NoError(t, err)
+	assert.Len(t, urls, 50)
+}
+
+func TestMemoryStorage_ScanShortURLs(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStorage()
+	assert.NoError(t, store.SaveURL(ctx, &models.URLData{OriginalURL: "http://a.com", ShortURL: "a", UserID: "user1"}))
+	assert.NoError(t, store.SaveURL(ctx, &models.URLData{OriginalURL: "http://b.com", ShortURL: "b", Alias: "promo", UserID: "user1"}))
+	_, err := store.DeleteURLs(ctx, "user1", []string{"a"})
+	assert.NoError(t, err)
+
+	var codes []string
+	assert.NoError(t, store.ScanShortURLs(ctx, func(shortURL string) error {
+		codes = append(codes, shortURL)
+		return nil
+	}))
+	assert.Equal(t, []string{"a", "b"}, codes)
+}
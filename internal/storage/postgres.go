@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/thalq/url-service/internal/models"
+	"github.com/thalq/url-service/internal/operations"
+)
+
+type PostgresStorage struct {
+	db *sql.DB
+}
+
+func NewPostgresStorage(db *sql.DB) *PostgresStorage {
+	return &PostgresStorage{db: db}
+}
+
+// DB возвращает подключение, через которое работает хранилище.
+func (s *PostgresStorage) DB() *sql.DB {
+	return s.db
+}
+
+// Нарушение originalURLConstraint означает, что такая ссылка уже сокращена,
+// нарушение первичного ключа по short_url — коллизия кода.
+const (
+	originalURLConstraint = "urls_original_url_key"
+	shortURLConstraint    = "urls_pkey"
+	aliasConstraint       = "urls_alias_key"
+)
+
+func mapInsertError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		switch pgErr.ConstraintName {
+		case originalURLConstraint:
+			return ErrConflict
+		case shortURLConstraint:
+			return ErrShortURLTaken
+		case aliasConstraint:
+			return ErrAliasTaken
+		}
+	}
+	return err
+}
+
+// codeTaken проверяет то, чего не покрывают уникальные индексы: alias не
+// должен совпадать со сгенерированным кодом, а сгенерированный код —
+// с чужим alias. Иначе поиск по коду вернул бы любую из двух ссылок.
+func (s *PostgresStorage) codeTaken(ctx context.Context, URLData *models.URLData) error {
+	if URLData.Alias != "" {
+		taken, err := operations.CodeExists(ctx, s.db, URLData.Alias)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrAliasTaken
+		}
+	}
+	taken, err := operations.AliasExists(ctx, s.db, URLData.ShortURL)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrShortURLTaken
+	}
+	return nil
+}
+
+func (s *PostgresStorage) SaveURL(ctx context.Context, URLData *models.URLData) error {
+	if err := s.codeTaken(ctx, URLData); err != nil {
+		return err
+	}
+	return mapInsertError(operations.InsertURL(ctx, s.db, URLData))
+}
+
+// SaveBatch проверяет коды всего пакета одним запросом до вставки и
+// original_url невставленных ссылок одним запросом после: на импорте
+// тысяч ссылок запросы по каждой ссылке отдельно слишком дороги.
+func (s *PostgresStorage) SaveBatch(ctx context.Context, URLData []*models.URLData) ([]error, error) {
+	codes := make([]string, 0, len(URLData))
+	for _, data := range URLData {
+		codes = append(codes, data.ShortURL)
+		if data.Alias != "" {
+			codes = append(codes, data.Alias)
+		}
+	}
+	takenShort, takenAlias, err := operations.TakenCodes(ctx, s.db, codes)
+	if err != nil {
+		return nil, err
+	}
+
+	itemErrs := make([]error, len(URLData))
+	var toInsert []*models.URLData
+	var insertIdx []int
+	for i, data := range URLData {
+		// как в codeTaken: alias не должен совпадать ни с каким кодом, а
+		// сгенерированный код — с чужим alias, совпадения short_url ловит вставка
+		switch {
+		case data.Alias != "" && (takenShort[data.Alias] || takenAlias[data.Alias]):
+			itemErrs[i] = ErrAliasTaken
+		case takenAlias[data.ShortURL]:
+			itemErrs[i] = ErrShortURLTaken
+		default:
+			toInsert = append(toInsert, data)
+			insertIdx = append(insertIdx, i)
+		}
+	}
+	if len(toInsert) == 0 {
+		return itemErrs, nil
+	}
+
+	inserted, err := operations.ExecInsertBatchURLs(ctx, s.db, toInsert)
+	if err != nil {
+		return nil, err
+	}
+	var skipped []string
+	for k, data := range toInsert {
+		if inserted[k] {
+			// коды вставленных ссылок заняты и для остальных ссылок пакета
+			takenShort[data.ShortURL] = true
+			if data.Alias != "" {
+				takenAlias[data.Alias] = true
+			}
+			continue
+		}
+		skipped = append(skipped, data.OriginalURL)
+	}
+	if len(skipped) == 0 {
+		return itemErrs, nil
+	}
+	existing, err := operations.ExistingOriginalURLs(ctx, s.db, skipped)
+	if err != nil {
+		return nil, err
+	}
+	for k, data := range toInsert {
+		if inserted[k] {
+			continue
+		}
+		// не вставилась: original_url уже есть, либо занят alias или код
+		i := insertIdx[k]
+		switch {
+		case existing[data.OriginalURL]:
+			itemErrs[i] = ErrConflict
+		case data.Alias != "" && (takenShort[data.Alias] || takenAlias[data.Alias]):
+			itemErrs[i] = ErrAliasTaken
+		default:
+			itemErrs[i] = ErrShortURLTaken
+		}
+	}
+	return itemErrs, nil
+}
+
+func (s *PostgresStorage) GetURL(ctx context.Context, shortURL string) (models.URLData, error) {
+	URLData, err := operations.GetURLData(ctx, s.db, shortURL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return URLData, ErrNotFound
+	}
+	return URLData, err
+}
+
+func (s *PostgresStorage) GetByOriginalURL(ctx context.Context, originalURL string) (models.URLData, error) {
+	URLData, err := operations.GetURLDataByOriginal(ctx, s.db, originalURL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return URLData, ErrNotFound
+	}
+	return URLData, err
+}
+
+func (s *PostgresStorage) Visit(ctx context.Context, shortURL string) error {
+	ok, err := operations.IncrementClicks(ctx, s.db, shortURL)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrClicksExhausted
+	}
+	return nil
+}
+
+func (s *PostgresStorage) GetUserURLs(ctx context.Context, userID string) ([]models.URLData, error) {
+	return operations.GetUserURLData(ctx, s.db, userID)
+}
+
+func (s *PostgresStorage) UpdateURL(ctx context.Context, userID, shortURL, originalURL string) (models.URLData, error) {
+	URLData, err := operations.RetargetURL(ctx, s.db, userID, shortURL, originalURL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return URLData, ErrNotFound
+	}
+	return URLData, mapInsertError(err)
+}
+
+func (s *PostgresStorage) GetURLHistory(ctx context.Context, shortURL string) ([]models.URLHistory, error) {
+	return operations.GetURLHistory(ctx, s.db, shortURL)
+}
+
+func (s *PostgresStorage) DeleteURLs(ctx context.Context, userID string, shortURLs []string) ([]string, error) {
+	return operations.DeleteUserURLs(ctx, s.db, userID, shortURLs)
+}
+
+func (s *PostgresStorage) RestoreURLs(ctx context.Context, userID string, shortURLs []string, since time.Time) ([]string, error) {
+	return operations.RestoreURLs(ctx, s.db, userID, shortURLs, since)
+}
+
+func (s *PostgresStorage) PurgeDeleted(ctx context.Context, before time.Time) ([]string, error) {
+	return operations.PurgeDeleted(ctx, s.db, before)
+}
+
+func (s *PostgresStorage) ExpireURLs(ctx context.Context, now time.Time) (int, error) {
+	count, err := operations.ExpireURLs(ctx, s.db, now)
+	return int(count), err
+}
+
+func (s *PostgresStorage) ScanShortURLs(ctx context.Context, fn func(shortURL string) error) error {
+	return operations.ScanShortURLs(ctx, s.db, fn)
+}
+
+func (s *PostgresStorage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
@@ -0,0 +1,309 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	logger "github.com/thalq/url-service/internal/middleware"
+	"github.com/thalq/url-service/internal/models"
+)
+
+// urlColumns — колонки, которые читает operations.scanURLData.
+var urlColumns = []string{"original_url", "short_url", "correlation_id", "user_id", "is_deleted", "alias", "expires_at", "max_clicks", "clicks", "password_hash", "updated_at", "deleted_at", "rules", "variants"}
+
+func TestPostgresStorage_SaveURL(t *testing.T) {
+	logger.InitLogger()
+	urlData := &models.URLData{OriginalURL: "http://example.com", ShortURL: "exmpl", CorrelationID: "1", UserID: "user1"}
+
+	tests := []struct {
+		name    string
+		execErr error
+		wantErr error
+	}{
+		{
+			name:    "saved",
+			execErr: nil,
+			wantErr: nil,
+		},
+		{
+			name:    "original url conflict",
+			execErr: &pgconn.PgError{Code: pgerrcode.UniqueViolation, ConstraintName: "urls_original_url_key"},
+			wantErr: ErrConflict,
+		},
+		{
+			name:    "short url collision",
+			execErr: &pgconn.PgError{Code: pgerrcode.UniqueViolation, ConstraintName: "urls_pkey"},
+			wantErr: ErrShortURLTaken,
+		},
+		{
+			name:    "alias race",
+			execErr: &pgconn.PgError{Code: pgerrcode.UniqueViolation, ConstraintName: "urls_alias_key"},
+			wantErr: ErrAliasTaken,
+		},
+		{
+			name:    "other error is not a conflict",
+			execErr: context.DeadlineExceeded,
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			expectAliasExists(mock, urlData.ShortURL, false)
+			exec := mock.ExpectExec(regexp.QuoteMeta("INSERT INTO urls")).
+				WithArgs(urlData.OriginalURL, urlData.ShortURL, urlData.CorrelationID, urlData.UserID, "", nil, 0, "", nil, nil)
+			if tt.execErr != nil {
+				exec.WillReturnError(tt.execErr)
+			} else {
+				exec.WillReturnResult(sqlmock.NewResult(1, 1))
+			}
+
+			err = NewPostgresStorage(db).SaveURL(context.Background(), urlData)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, errors.Is(err, tt.wantErr), "got %v", err)
+			}
+			if tt.wantErr != ErrConflict {
+				assert.False(t, errors.Is(err, ErrConflict))
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPostgresStorage_GetByOriginalURL(t *testing.T) {
+	logger.InitLogger()
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT original_url, short_url, correlation_id, user_id, is_deleted, (.+) FROM urls").
+		WithArgs("http://example.com").
+		WillReturnRows(sqlmock.NewRows(urlColumns).
+			AddRow("http://example.com", "stored", "1", "user1", false, "", nil, 0, 0, "", nil, nil,
+				[]byte(`[{"device":["mobile"],"url":"http://m.example.com"}]`), []byte(`[{"name":"a","url":"http://a.example.com","weight":3}]`)))
+
+	got, err := NewPostgresStorage(db).GetByOriginalURL(context.Background(), "http://example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "stored", got.ShortURL)
+	assert.Equal(t, []models.RedirectRule{{Device: []string{"mobile"}, URL: "http://m.example.com"}}, got.Rules)
+	assert.Equal(t, []models.Variant{{Name: "a", URL: "http://a.example.com", Weight: 3}}, got.Variants)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStorage_SaveBatch(t *testing.T) {
+	logger.InitLogger()
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(anyArgs{}))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	batch := []*models.URLData{
+		{OriginalURL: "http://example.com", ShortURL: "exmpl1", CorrelationID: "1", UserID: "user1"},
+		{OriginalURL: "http://example.org", ShortURL: "exmpl2", CorrelationID: "2", UserID: "user1"},
+		{OriginalURL: "http://example.net", ShortURL: "exmpl1", CorrelationID: "3", UserID: "user1"},
+		{OriginalURL: "http://example.info", ShortURL: "exmpl4", CorrelationID: "4", UserID: "user1", Alias: "taken"},
+		{OriginalURL: "http://example.biz", ShortURL: "promo", CorrelationID: "5", UserID: "user1"},
+	}
+	// все коды пакета проверяются одним запросом
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT short_url, COALESCE(alias, '') FROM urls WHERE short_url = ANY($1) OR alias = ANY($1)")).
+		WithArgs([]string{"exmpl1", "exmpl2", "exmpl1", "exmpl4", "taken", "promo"}).
+		WillReturnRows(sqlmock.NewRows([]string{"short_url", "alias"}).AddRow("taken", "").AddRow("other", "promo"))
+	mock.ExpectBegin()
+	prep := mock.ExpectPrepare(regexp.QuoteMeta("ON CONFLICT DO NOTHING"))
+	prep.ExpectExec().WithArgs("http://example.com", "exmpl1", "1", "user1", "", nil, 0, "", nil, nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	prep.ExpectExec().WithArgs("http://example.org", "exmpl2", "2", "user1", "", nil, 0, "", nil, nil).WillReturnResult(sqlmock.NewResult(0, 0))
+	prep.ExpectExec().WithArgs("http://example.net", "exmpl1", "3", "user1", "", nil, 0, "", nil, nil).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	// и original_url невставленных ссылок — тоже одним
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT original_url FROM urls WHERE original_url = ANY($1)")).
+		WithArgs([]string{"http://example.org", "http://example.net"}).
+		WillReturnRows(sqlmock.NewRows([]string{"original_url"}).AddRow("http://example.org"))
+
+	itemErrs, err := NewPostgresStorage(db).SaveBatch(context.Background(), batch)
+	assert.NoError(t, err)
+	assert.NoError(t, itemErrs[0])
+	assert.ErrorIs(t, itemErrs[1], ErrConflict)
+	assert.ErrorIs(t, itemErrs[2], ErrShortURLTaken)
+	assert.ErrorIs(t, itemErrs[3], ErrAliasTaken)
+	assert.ErrorIs(t, itemErrs[4], ErrShortURLTaken)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func expectAliasExists(mock sqlmock.Sqlmock, code string, exists bool) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM urls WHERE alias = $1)")).WithArgs(code).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(exists))
+}
+
+func TestPostgresStorage_SaveURLCodeIsAlias(t *testing.T) {
+	logger.InitLogger()
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// сгенерированный код совпал с чужим alias: вставки нет, вызывающий
+	// возьмёт следующий код
+	expectAliasExists(mock, "exmpl", true)
+
+	urlData := &models.URLData{OriginalURL: "http://example.com", ShortURL: "exmpl", UserID: "user1"}
+	err = NewPostgresStorage(db).SaveURL(context.Background(), urlData)
+	assert.ErrorIs(t, err, ErrShortURLTaken)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStorage_SaveURLAliasTaken(t *testing.T) {
+	logger.InitLogger()
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT EXISTS").WithArgs("spring-sale").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	urlData := &models.URLData{OriginalURL: "http://example.com", ShortURL: "exmpl", Alias: "spring-sale", UserID: "user1"}
+	err = NewPostgresStorage(db).SaveURL(context.Background(), urlData)
+	assert.ErrorIs(t, err, ErrAliasTaken)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStorage_ExpireURLs(t *testing.T) {
+	logger.InitLogger()
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE urls SET is_deleted = true, deleted_at = $1 WHERE expires_at <= $1")).
+		WithArgs(now).WillReturnResult(sqlmock.NewResult(0, 2))
+
+	count, err := NewPostgresStorage(db).ExpireURLs(context.Background(), now)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStorage_Visit(t *testing.T) {
+	logger.InitLogger()
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	visit := regexp.QuoteMeta("UPDATE urls SET clicks = clicks + 1")
+	mock.ExpectExec(visit).WithArgs("invite").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(visit).WithArgs("invite").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	store := NewPostgresStorage(db)
+	assert.NoError(t, store.Visit(context.Background(), "invite"))
+	assert.ErrorIs(t, store.Visit(context.Background(), "invite"), ErrClicksExhausted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStorage_UpdateURL(t *testing.T) {
+	logger.InitLogger()
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	updatedAt := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM urls WHERE short_url = (.+) FOR UPDATE").WithArgs("code", "user1").
+		WillReturnRows(sqlmock.NewRows(urlColumns).
+			AddRow("http://old.com", "code", "1", "user1", false, "", nil, 0, 0, "", nil, nil, nil, nil))
+	mock.ExpectExec("INSERT INTO url_history").WithArgs("code", "http://old.com").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("UPDATE urls SET original_url").WithArgs("code", "http://new.com").
+		WillReturnRows(sqlmock.NewRows([]string{"updated_at"}).AddRow(updatedAt))
+	mock.ExpectCommit()
+
+	got, err := NewPostgresStorage(db).UpdateURL(context.Background(), "user1", "code", "http://new.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://new.com", got.OriginalURL)
+	assert.Equal(t, updatedAt, *got.UpdatedAt)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM urls WHERE short_url = (.+) FOR UPDATE").WithArgs("code", "user1").
+		WillReturnRows(sqlmock.NewRows(urlColumns).
+			AddRow("http://new.com", "code", "1", "user1", false, "", nil, 0, 0, "", nil, nil, nil, nil))
+	mock.ExpectExec("INSERT INTO url_history").WithArgs("code", "http://new.com").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("UPDATE urls SET original_url").WithArgs("code", "http://taken.com").
+		WillReturnError(&pgconn.PgError{Code: pgerrcode.UniqueViolation, ConstraintName: "urls_original_url_key"})
+	mock.ExpectRollback()
+
+	_, err = NewPostgresStorage(db).UpdateURL(context.Background(), "user1", "code", "http://taken.com")
+	assert.ErrorIs(t, err, ErrConflict)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// anyArgs пропускает срезы как есть: стандартный конвертер sqlmock
+// не принимает срезы, которые pgx передаёт в Postgres как массивы.
+type anyArgs struct{}
+
+func (anyArgs) ConvertValue(v any) (driver.Value, error) {
+	if _, ok := v.([]string); ok {
+		return v, nil
+	}
+	return driver.DefaultParameterConverter.ConvertValue(v)
+}
+
+func TestPostgresStorage_RestoreURLs(t *testing.T) {
+	logger.InitLogger()
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(anyArgs{}))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	since := time.Now().Add(-time.Hour)
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE urls SET is_deleted = false, deleted_at = NULL")).
+		WithArgs("user1", []string{"a", "b"}, since).
+		WillReturnRows(sqlmock.NewRows([]string{"short_url"}).AddRow("a"))
+
+	restored, err := NewPostgresStorage(db).RestoreURLs(context.Background(), "user1", []string{"a", "b"}, since)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, restored)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStorage_PurgeDeleted(t *testing.T) {
+	logger.InitLogger()
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	before := time.Now()
+	// переходы удаляются тем же запросом, что и ссылки
+	mock.ExpectQuery(regexp.QuoteMeta("WITH purged AS (DELETE FROM urls WHERE is_deleted AND deleted_at < $1 RETURNING short_url), " +
+		"purged_clicks AS (DELETE FROM clicks WHERE short_url IN (SELECT short_url FROM purged))")).
+		WithArgs(before).WillReturnRows(sqlmock.NewRows([]string{"short_url"}).AddRow("a").AddRow("b"))
+
+	purged, err := NewPostgresStorage(db).PurgeDeleted(context.Background(), before)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, purged)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStorage_DeleteURLs(t *testing.T) {
+	logger.InitLogger()
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(anyArgs{}))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE urls SET is_deleted = true, deleted_at = CASE WHEN is_deleted THEN deleted_at ELSE now() END WHERE user_id = $1 AND (short_url = ANY($2) OR alias = ANY($2)) RETURNING short_url, COALESCE(alias, '')")).
+		WithArgs("user1", []string{"a", "b", "c"}).
+		WillReturnRows(sqlmock.NewRows([]string{"short_url", "alias"}).AddRow("a", "").AddRow("x", "b"))
+
+	deleted, err := NewPostgresStorage(db).DeleteURLs(context.Background(), "user1", []string{"a", "b", "c"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, deleted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
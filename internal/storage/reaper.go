@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	logger "github.com/thalq/url-service/internal/middleware"
+)
+
+// StartReaper периодически помечает удалёнными ссылки с истёкшим сроком
+// жизни. Редиректы по таким ссылкам отдают 410 и без него, а reaper
+// приводит хранилище в соответствие, чтобы их видели и остальные запросы.
+func StartReaper(store Storage, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			count, err := store.ExpireURLs(ctx, time.Now())
+			cancel()
+			if err != nil {
+				logger.Sugar.Errorf("Failed to expire URLs: %v", err)
+				continue
+			}
+			if count > 0 {
+				logger.Sugar.Infof("Expired %d URLs", count)
+			}
+		}
+	}()
+}
+
+// StartPurger периодически физически удаляет ссылки, помеченные удалёнными
+// раньше, чем retention назад. onPurge получает коды удалённых ссылок,
+// чтобы вместе с ними удалить и их переходы: код может достаться новой
+// ссылке, и её статистика не должна начинаться с чужих посетителей.
+func StartPurger(store Storage, interval, retention time.Duration, onPurge func(ctx context.Context, shortURLs []string) error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			purged, err := store.PurgeDeleted(ctx, time.Now().Add(-retention))
+			if err != nil {
+				cancel()
+				logger.Sugar.Errorf("Failed to purge deleted URLs: %v", err)
+				continue
+			}
+			if len(purged) > 0 {
+				logger.Sugar.Infof("Purged %d deleted URLs", len(purged))
+				if onPurge != nil {
+					if err := onPurge(ctx, purged); err != nil {
+						logger.Sugar.Errorf("Failed to delete clicks of %d purged URLs: %v", len(purged), err)
+					}
+				}
+			}
+			cancel()
+		}
+	}()
+}
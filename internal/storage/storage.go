@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/thalq/url-service/internal/models"
+	"github.com/thalq/url-service/internal/shortener"
+)
+
+var (
+	ErrNotFound = errors.New("short url not found")
+	ErrConflict = errors.New("original url already exists")
+	// ErrShortURLTaken — код уже занят другой ссылкой, то есть коллизия хеша.
+	ErrShortURLTaken = fmt.Errorf("short url is taken: %w", shortener.ErrCollision)
+	ErrAliasTaken    = errors.New("alias is taken")
+	// ErrClicksExhausted — у ссылки закончился лимит переходов.
+	ErrClicksExhausted = errors.New("click limit reached")
+	// ErrStorageLocked — файл хранилища уже открыт другим процессом.
+	ErrStorageLocked = errors.New("file storage is locked by another process")
+)
+
+// Storage — общее хранилище сокращённых ссылок, с которым работают хендлеры.
+// Реализации: PostgresStorage, FileStorage и MemoryStorage.
+type Storage interface {
+	SaveURL(ctx context.Context, URLData *models.URLData) error
+	// SaveBatch сохраняет новые ссылки и пропускает уже существующие:
+	// itemErrs[i] равен ErrConflict, если original_url i-й ссылки уже сокращён,
+	// ErrShortURLTaken, если её код занят другой ссылкой, и ErrAliasTaken,
+	// если занят её alias.
+	SaveBatch(ctx context.Context, URLData []*models.URLData) (itemErrs []error, err error)
+	// GetURL ищет ссылку по сгенерированному коду или по alias.
+	GetURL(ctx context.Context, shortURL string) (models.URLData, error)
+	GetByOriginalURL(ctx context.Context, originalURL string) (models.URLData, error)
+	// Visit атомарно засчитывает переход по ссылке с кодом shortURL или
+	// возвращает ErrClicksExhausted, если лимит переходов уже исчерпан.
+	Visit(ctx context.Context, shortURL string) error
+	GetUserURLs(ctx context.Context, userID string) ([]models.URLData, error)
+	// UpdateURL перенаправляет ссылку пользователя с кодом shortURL на новый
+	// originalURL, прежний адрес попадает в историю. Возвращает ErrNotFound,
+	// если живой ссылки с таким кодом у пользователя нет, и ErrConflict, если
+	// originalURL уже сокращён.
+	UpdateURL(ctx context.Context, userID, shortURL, originalURL string) (models.URLData, error)
+	// GetURLHistory возвращает прежние исходные URL ссылки, от старых к новым.
+	GetURLHistory(ctx context.Context, shortURL string) ([]models.URLHistory, error)
+	// DeleteURLs помечает удалёнными ссылки пользователя и возвращает те из
+	// shortURLs, что ему принадлежат, включая удалённые раньше. Чужие
+	// и несуществующие коды пропускаются.
+	DeleteURLs(ctx context.Context, userID string, shortURLs []string) ([]string, error)
+	// RestoreURLs снимает пометку удаления со ссылок пользователя, удалённых
+	// не раньше since и ещё не истёкших, и возвращает их коды.
+	RestoreURLs(ctx context.Context, userID string, shortURLs []string, since time.Time) ([]string, error)
+	// PurgeDeleted физически удаляет ссылки, помеченные удалёнными раньше
+	// before, и возвращает их коды.
+	PurgeDeleted(ctx context.Context, before time.Time) ([]string, error)
+	// ExpireURLs помечает удалёнными ссылки, срок жизни которых истёк к now,
+	// и возвращает их число.
+	ExpireURLs(ctx context.Context, now time.Time) (int, error)
+	// ScanShortURLs передаёт в fn сгенерированные коды всех ссылок, включая
+	// удалённые, но не alias.
+	ScanShortURLs(ctx context.Context, fn func(shortURL string) error) error
+	Ping(ctx context.Context) error
+}